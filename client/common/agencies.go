@@ -0,0 +1,90 @@
+package common
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// AgencyResult Outcome of the lottery flow of one of the agencies run by
+// RunAgencies
+type AgencyResult struct {
+	Agency  string
+	Summary Summary
+	Err     error
+}
+
+// RunAgencies Runs the lottery flow of every agency concurrently, each one
+// with its own client, and therefore its own connection and checkpoint.
+// Returns once every agency finished, logging the outcome of each of them
+// and a combined summary. The context error is returned if ctx was
+// cancelled, otherwise an error is returned if some agency failed
+func RunAgencies(ctx context.Context, configs []ClientConfig) ([]AgencyResult, error) {
+	results := make([]AgencyResult, len(configs))
+
+	var wg sync.WaitGroup
+	for i, config := range configs {
+		wg.Add(1)
+		go func(result *AgencyResult, config ClientConfig) {
+			defer wg.Done()
+			client := NewClient(config)
+			result.Agency = config.ID
+			result.Err = client.StartLottery(ctx)
+			result.Summary = client.Summary()
+		}(&results[i], config)
+	}
+	wg.Wait()
+
+	failed := logAgenciesSummary(results)
+	if ctx.Err() != nil {
+		return results, ctx.Err()
+	}
+	if failed > 0 {
+		return results, errors.Errorf("%d of %d agencies failed", failed, len(results))
+	}
+	return results, nil
+}
+
+// logAgenciesSummary Logs the outcome of every agency and their totals.
+// Returns the amount of agencies that failed
+func logAgenciesSummary(results []AgencyResult) int {
+	var total Summary
+	failed := 0
+	for _, result := range results {
+		total.BetsSent += result.Summary.BetsSent
+		total.Rejected += result.Summary.Rejected
+		total.Winners += result.Summary.Winners
+		if result.Err != nil {
+			failed++
+			log.Errorf("action: resumen_agencia | result: fail | client_id: %v | apuestas: %v | rechazadas: %v | error: %v",
+				result.Agency,
+				result.Summary.BetsSent,
+				result.Summary.Rejected,
+				result.Err,
+			)
+			continue
+		}
+		log.Infof("action: resumen_agencia | result: success | client_id: %v | apuestas: %v | rechazadas: %v | ganadores: %v",
+			result.Agency,
+			result.Summary.BetsSent,
+			result.Summary.Rejected,
+			result.Summary.Winners,
+		)
+	}
+
+	status := "success"
+	if failed > 0 {
+		status = "fail"
+	}
+	log.Infof("action: resumen_agencias | result: %v | agencias: %v | fallidas: %v | apuestas: %v | rechazadas: %v | ganadores: %v",
+		status,
+		len(results),
+		failed,
+		total.BetsSent,
+		total.Rejected,
+		total.Winners,
+	)
+	return failed
+}
@@ -0,0 +1,96 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// lotteryHandler Answers the lottery flow of the given amount of agencies,
+// performing the draw once all of them finished. Every agency has a single
+// winner
+func lotteryHandler(agencies int) testHandler {
+	finished := 0
+	return func(msgType protocol.MessageType, payload []byte) (protocol.MessageType, []byte) {
+		switch msgType {
+		case protocol.MsgBatch:
+			return ackBatch(msgType, payload)
+		case protocol.MsgFinished:
+			finished++
+			return protocol.MsgAck, nil
+		}
+		if finished < agencies {
+			return protocol.MsgDrawNotReady, nil
+		}
+		var buf bytes.Buffer
+		writeUint32(&buf, 1)
+		writeString(&buf, "30904465")
+		return protocol.MsgWinnersResponse, buf.Bytes()
+	}
+}
+
+func agencyTestConfig(t *testing.T, id string, address string) ClientConfig {
+	dir := t.TempDir()
+	return ClientConfig{
+		ID:                   id,
+		ServerAddresses:      []string{address},
+		DialMaxAttempts:      1,
+		WinnersBackoff:       BackoffConfig{InitialDelay: time.Millisecond, Multiplier: 2, MaxDelay: 10 * time.Millisecond},
+		BatchMaxAmount:       2,
+		BatchMaxBytes:        DefaultBatchMaxBytes,
+		DatasetPath:          writeTestDataset(t),
+		CheckpointPath:       filepath.Join(dir, "checkpoint.json"),
+		RejectsPath:          filepath.Join(dir, "rejects.csv"),
+		PersistentConnection: true,
+	}
+}
+
+func TestRunAgenciesRunsEveryAgencyConcurrently(t *testing.T) {
+	server := startTestServer(t, lotteryHandler(3))
+
+	configs := []ClientConfig{
+		agencyTestConfig(t, "1", server.Address),
+		agencyTestConfig(t, "2", server.Address),
+		agencyTestConfig(t, "3", server.Address),
+	}
+	results, err := RunAgencies(context.Background(), configs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for i, result := range results {
+		if result.Agency != configs[i].ID || result.Err != nil {
+			t.Errorf("expected agency %v to succeed, got %+v", configs[i].ID, result)
+		}
+		if result.Summary.BetsSent != 3 || result.Summary.Winners != 1 {
+			t.Errorf("expected 3 bets sent and 1 winner for agency %v, got %+v", result.Agency, result.Summary)
+		}
+	}
+	if server.Connections() != 3 {
+		t.Errorf("expected a connection per agency, got %d", server.Connections())
+	}
+}
+
+func TestRunAgenciesReportsFailedAgencies(t *testing.T) {
+	server := startTestServer(t, lotteryHandler(1))
+
+	failing := agencyTestConfig(t, "2", server.Address)
+	failing.DatasetPath = filepath.Join(t.TempDir(), "missing.csv")
+	results, err := RunAgencies(context.Background(), []ClientConfig{
+		agencyTestConfig(t, "1", server.Address),
+		failing,
+	})
+	if err == nil {
+		t.Fatal("expected error when an agency fails")
+	}
+	if results[0].Err != nil || results[0].Summary.Winners != 1 {
+		t.Errorf("expected agency 1 to succeed, got %+v", results[0])
+	}
+	if results[1].Err == nil {
+		t.Errorf("expected agency 2 to fail")
+	}
+}
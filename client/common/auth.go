@@ -0,0 +1,22 @@
+package common
+
+import (
+	"bytes"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// loadAuthSecret Reads the secret shared with the server to sign frames.
+// Surrounding whitespace, like a trailing newline, is not part of it
+func loadAuthSecret(path string) ([]byte, error) {
+	secret, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read auth secret")
+	}
+	secret = bytes.TrimSpace(secret)
+	if len(secret) == 0 {
+		return nil, errors.Errorf("auth secret file %s is empty", path)
+	}
+	return secret, nil
+}
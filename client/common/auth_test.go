@@ -0,0 +1,138 @@
+package common
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+func writeTestSecret(t *testing.T, secret string) string {
+	path := filepath.Join(t.TempDir(), "secret")
+	if err := ioutil.WriteFile(path, []byte(secret), 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+func TestSendBetWithSignedFrames(t *testing.T) {
+	server := startSigningTestServer(t, []byte("agency-1-secret"), ackRequest)
+
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.Address},
+		DialMaxAttempts: 1,
+		AuthSecretFile:  writeTestSecret(t, "agency-1-secret\n"),
+	})
+	defer client.Close()
+
+	if err := client.SendBet(context.Background(), newTestBet()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSendBetWithInvalidReplySignatureMustFailWithoutRetrying(t *testing.T) {
+	listener := listenTestServer(t)
+	defer listener.Close()
+
+	connections := 0
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connections++
+			framed := protocol.NewConn(conn)
+			framed.SetSigningKey([]byte("agency-1-secret"))
+			if _, _, err := framed.ReadFrame(context.Background()); err == nil {
+				// Reply signed with a key the client does not share
+				framed.SetSigningKey([]byte("another-secret"))
+				framed.WriteFrame(context.Background(), protocol.MsgAck, nil)
+			}
+			framed.Close()
+		}
+	}()
+
+	client := NewClient(ClientConfig{
+		ID:                   "1",
+		ServerAddresses:      []string{listener.Addr().String()},
+		DialMaxAttempts:      1,
+		PersistentConnection: true,
+		AuthSecretFile:       writeTestSecret(t, "agency-1-secret"),
+	})
+	defer client.Close()
+
+	err := client.SendBet(context.Background(), newTestBet())
+	if !protocol.IsSignatureInvalid(err) {
+		t.Fatalf("expected invalid signature error, got %v", err)
+	}
+
+	listener.Close()
+	<-done
+	if connections != 1 {
+		t.Errorf("expected a single connection, got %d", connections)
+	}
+}
+
+func TestLoadAuthSecretFromEmptyFileMustFail(t *testing.T) {
+	if _, err := loadAuthSecret(writeTestSecret(t, "\n")); err == nil {
+		t.Fatal("expected error loading an empty secret")
+	}
+}
+
+func TestSendBatchesSignedFramesNeverExceedBatchMaxBytes(t *testing.T) {
+	key := []byte("agency-1-secret")
+	server := startSigningTestServer(t, key, ackBatch)
+
+	// A budget that fits 16 bets and the framing without the signature
+	maxBytes := protocol.HeaderSize + protocol.TrailerSize + batchHeaderSize + batchCountSize + 16*generatedBetSize(t) + 2
+	client := NewClient(ClientConfig{
+		ID:                   "1",
+		ServerAddresses:      []string{server.Address},
+		DialMaxAttempts:      1,
+		BatchMaxAmount:       1000,
+		BatchMaxBytes:        maxBytes,
+		DatasetPath:          writeLargeTestDataset(t, 200),
+		PersistentConnection: true,
+		AuthSecretFile:       writeTestSecret(t, string(key)),
+	})
+	defer client.Close()
+
+	if err := client.SendBatches(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertFullFrames(t, server.MaxFrame(), maxBytes)
+}
+
+func TestSendBatchesSignedCompressedFramesNeverExceedBatchMaxBytes(t *testing.T) {
+	key := []byte("agency-1-secret")
+	server := serveTestListener(t, listenTestServer(t), func(conn *protocol.Conn) {
+		conn.SetCompression(0)
+		conn.SetSigningKey(key)
+	}, acceptHandshake(protocol.FeatureCompression, ackBatch))
+
+	maxBytes := 512
+	client := NewClient(ClientConfig{
+		ID:                   "1",
+		ServerAddresses:      []string{server.Address},
+		DialMaxAttempts:      1,
+		BatchMaxAmount:       1000,
+		BatchMaxBytes:        maxBytes,
+		DatasetPath:          writeLargeTestDataset(t, 500),
+		PersistentConnection: true,
+		AuthSecretFile:       writeTestSecret(t, string(key)),
+		Handshake:            true,
+		Compression:          true,
+	})
+	defer client.Close()
+
+	if err := client.SendBatches(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertFullFrames(t, server.MaxFrame(), maxBytes)
+}
@@ -0,0 +1,60 @@
+package common
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// BackoffConfig Parameters of an exponential backoff policy
+type BackoffConfig struct {
+	// InitialDelay Delay before the first retry
+	InitialDelay time.Duration
+	// Multiplier Factor applied to the delay after every retry
+	Multiplier float64
+	// MaxDelay Upper bound of the delay between two retries
+	MaxDelay time.Duration
+	// Jitter Fraction of the delay randomly added or subtracted, between 0 and 1
+	Jitter float64
+	// MaxElapsed Time after which no more retries are allowed. Zero means
+	// there is no limit
+	MaxElapsed time.Duration
+}
+
+// Backoff Computes the delays between consecutive retries of an operation
+// following an exponential backoff policy with jitter
+type Backoff struct {
+	config  BackoffConfig
+	attempt int
+	start   time.Time
+	rand    *rand.Rand
+}
+
+// NewBackoff Initializes a backoff policy. The elapsed time is measured
+// from this moment
+func NewBackoff(config BackoffConfig) *Backoff {
+	return &Backoff{
+		config: config,
+		start:  time.Now(),
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Next Returns the delay to wait before the next retry. false is returned
+// if the retry would happen after the maximum elapsed time
+func (b *Backoff) Next() (time.Duration, bool) {
+	delay := float64(b.config.InitialDelay) * math.Pow(b.config.Multiplier, float64(b.attempt))
+	if max := float64(b.config.MaxDelay); max > 0 && delay > max {
+		delay = max
+	}
+	if b.config.Jitter > 0 {
+		delay += delay * b.config.Jitter * (2*b.rand.Float64() - 1)
+	}
+	b.attempt++
+
+	wait := time.Duration(delay)
+	if b.config.MaxElapsed > 0 && time.Since(b.start)+wait > b.config.MaxElapsed {
+		return 0, false
+	}
+	return wait, true
+}
@@ -0,0 +1,62 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffGrowsExponentiallyUpToMaxDelay(t *testing.T) {
+	backoff := NewBackoff(BackoffConfig{
+		InitialDelay: 100 * time.Millisecond,
+		Multiplier:   2,
+		MaxDelay:     time.Second,
+	})
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for i, want := range expected {
+		got, ok := backoff.Next()
+		if !ok {
+			t.Fatalf("retry %d: unexpected end of retries", i)
+		}
+		if got != want {
+			t.Errorf("retry %d: expected %v, got %v", i, want, got)
+		}
+	}
+}
+
+func TestBackoffJitterKeepsDelayWithinBounds(t *testing.T) {
+	backoff := NewBackoff(BackoffConfig{
+		InitialDelay: time.Second,
+		Multiplier:   1,
+		Jitter:       0.5,
+	})
+
+	for i := 0; i < 100; i++ {
+		got, _ := backoff.Next()
+		if got < 500*time.Millisecond || got > 1500*time.Millisecond {
+			t.Fatalf("delay %v out of bounds", got)
+		}
+	}
+}
+
+func TestBackoffStopsAfterMaxElapsed(t *testing.T) {
+	backoff := NewBackoff(BackoffConfig{
+		InitialDelay: time.Second,
+		Multiplier:   2,
+		MaxElapsed:   1500 * time.Millisecond,
+	})
+
+	if _, ok := backoff.Next(); !ok {
+		t.Fatal("expected first retry to be allowed")
+	}
+	if _, ok := backoff.Next(); ok {
+		t.Fatal("expected retry after max elapsed time to be refused")
+	}
+}
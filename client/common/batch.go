@@ -0,0 +1,284 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// DefaultBatchMaxBytes Default maximum size in bytes of a batch payload
+const DefaultBatchMaxBytes = 8 * 1024
+
+// batchCountSize Amount of bytes used to encode the number of bets of a batch
+const batchCountSize = 4
+
+// batchIDSize Amount of bytes used to encode a batch ID: the agency as a
+// big endian uint32 followed by the dataset row of its first bet as a big
+// endian uint64
+const batchIDSize = 4 + 8
+
+// batchHeaderSize Amount of bytes that precede the bets of a batch: its ID
+// and the SHA-256 hash of its content
+const batchHeaderSize = batchIDSize + sha256.Size
+
+// BatchOverhead Amount of bytes of a batch payload that precede its bets:
+// the batch header followed by the amount of bets
+const BatchOverhead = batchHeaderSize + batchCountSize
+
+// BatchID Identifies a batch among all the batches sent by every agency.
+// Since it only depends on the dataset row where the batch starts, a batch
+// that is sent again keeps its ID, even after a client restart, and no
+// matter how batches are sized
+type BatchID struct {
+	Agency uint32
+	Row    uint64
+}
+
+// encode Serializes the batch ID: the agency as a big endian uint32
+// followed by the row as a big endian uint64
+func (id BatchID) encode() []byte {
+	buf := make([]byte, batchIDSize)
+	binary.BigEndian.PutUint32(buf[0:4], id.Agency)
+	binary.BigEndian.PutUint64(buf[4:batchIDSize], id.Row)
+	return buf
+}
+
+// decodeBatchID Parses the batch ID at the beginning of payload. The rest
+// of the payload is returned as well
+func decodeBatchID(payload []byte) (BatchID, []byte, error) {
+	if len(payload) < batchIDSize {
+		return BatchID{}, nil, errors.Errorf("payload of %d bytes does not contain a batch ID", len(payload))
+	}
+	id := BatchID{
+		Agency: binary.BigEndian.Uint32(payload[0:4]),
+		Row:    binary.BigEndian.Uint64(payload[4:batchIDSize]),
+	}
+	return id, payload[batchIDSize:], nil
+}
+
+// Batch Group of bets sent to the server in a single message
+type Batch struct {
+	Bets []Bet
+	// Seq Position of the batch among all the batches of the dataset
+	Seq uint64
+	// Row Dataset row of the first bet of the batch
+	Row int64
+	// Offset Amount of dataset rows consumed once this batch is stored
+	Offset int64
+	// Hash SHA-256 of the serialized bets of the batch
+	Hash    [sha256.Size]byte
+	payload []byte
+}
+
+// ID Returns the identifier of the batch
+func (b *Batch) ID() BatchID {
+	return BatchID{Agency: b.Bets[0].Agency, Row: uint64(b.Row)}
+}
+
+// Payload Returns the batch serialized: the batch ID, the hash of the
+// content and then the content itself, which is the amount of bets as a
+// big endian uint32 followed by every bet serialized
+func (b *Batch) Payload() []byte {
+	return b.payload
+}
+
+// BatchBuilder Groups the bets of a BetReader in batches of at most
+// maxAmount bets whose serialized size, header included, never exceeds
+// maxBytes. With compression enabled, the limit applies to the size of the
+// batch once compressed, so many more bets fit in a batch
+type BatchBuilder struct {
+	reader    *BetReader
+	maxAmount int
+	maxBytes  int
+	nextSeq   uint64
+	// pending Bets read but left out of the previous batches, the next one
+	// to be included last
+	pending []pendingBet
+	// compression Whether batches are compressed when sent, in which case
+	// payloads of at least compressionThreshold bytes are compressed
+	compression          bool
+	compressionThreshold int
+}
+
+// pendingBet Bet read from the dataset along with its row
+type pendingBet struct {
+	bet Bet
+	row int64
+}
+
+// NewBatchBuilder Initializes a batch builder over a bet reader. Batches
+// are numbered starting from firstSeq
+func NewBatchBuilder(reader *BetReader, firstSeq uint64, maxAmount int, maxBytes int) *BatchBuilder {
+	return &BatchBuilder{
+		reader:    reader,
+		nextSeq:   firstSeq,
+		maxAmount: maxAmount,
+		maxBytes:  maxBytes,
+	}
+}
+
+// SetCompression Makes batches be sized after their compressed payload,
+// as sent by a connection compressing payloads of at least threshold bytes
+func (b *BatchBuilder) SetCompression(threshold int) {
+	b.compression = true
+	b.compressionThreshold = threshold
+}
+
+// Next Returns the next batch of bets. io.EOF is returned once every bet
+// of the reader has been included in a batch. An error is returned if a
+// single bet does not fit in maxBytes
+func (b *BatchBuilder) Next() (*Batch, error) {
+	batch := &Batch{Seq: b.nextSeq}
+	var buf bytes.Buffer
+	buf.Write(make([]byte, BatchOverhead))
+
+	var rows []int64
+	var ends []int
+	// Amount of bets known to fit once compressed, and raw size at which
+	// the compressed size is checked again
+	fitting, checkAt := 0, b.maxBytes
+
+	for len(batch.Bets) < b.maxAmount {
+		bet, row, err := b.nextBet()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		encoded, err := bet.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		if !b.compression && buf.Len()+len(encoded) > b.maxBytes {
+			if len(batch.Bets) == 0 {
+				return nil, errors.Errorf("bet of %d bytes does not fit in a batch of %d bytes", len(encoded), b.maxBytes)
+			}
+			// Keep the bet for the next batch
+			b.unread(bet, row)
+			break
+		}
+		if len(batch.Bets) == 0 {
+			batch.Row = row
+		}
+		buf.Write(encoded)
+		batch.Bets = append(batch.Bets, bet)
+		rows = append(rows, row)
+		ends = append(ends, buf.Len())
+
+		if b.compression && buf.Len() > checkAt {
+			size, err := b.sentSize(batch, buf.Bytes(), ends, len(batch.Bets))
+			if err != nil {
+				return nil, err
+			}
+			if size > b.maxBytes {
+				break
+			}
+			// Check again once the batch would reach maxBytes keeping
+			// the same compression ratio
+			fitting = len(batch.Bets)
+			checkAt = buf.Len() * b.maxBytes / size
+		}
+	}
+
+	if len(batch.Bets) == 0 {
+		return nil, io.EOF
+	}
+	count := len(batch.Bets)
+	if b.compression {
+		var err error
+		if count, err = b.largestFitting(batch, buf.Bytes(), ends, fitting); err != nil {
+			return nil, err
+		}
+	}
+	// Keep the bets left out for the next batch, the first one on top
+	for i := len(batch.Bets) - 1; i >= count; i-- {
+		b.unread(batch.Bets[i], rows[i])
+	}
+
+	batch.Bets = batch.Bets[:count]
+	batch.Offset = rows[count-1]
+	batch.payload = buf.Bytes()[:ends[count-1]]
+	b.seal(batch)
+	b.nextSeq++
+	return batch, nil
+}
+
+// largestFitting Returns the largest amount of bets of the batch that fit
+// in maxBytes once compressed, knowing that the first fitting bets do. The
+// amount is searched with as few compressions as possible
+func (b *BatchBuilder) largestFitting(batch *Batch, buf []byte, ends []int, fitting int) (int, error) {
+	size, err := b.sentSize(batch, buf, ends, len(batch.Bets))
+	if err != nil || size <= b.maxBytes {
+		return len(batch.Bets), err
+	}
+
+	low, high := fitting, len(batch.Bets)
+	for high-low > 1 {
+		mid := (low + high) / 2
+		size, err := b.sentSize(batch, buf, ends, mid)
+		if err != nil {
+			return 0, err
+		}
+		if size <= b.maxBytes {
+			low = mid
+		} else {
+			high = mid
+		}
+	}
+	if low == 0 {
+		return 0, errors.Errorf("bet of %d bytes does not fit in a batch of %d bytes", ends[0]-batchHeaderSize-batchCountSize, b.maxBytes)
+	}
+	return low, nil
+}
+
+// sentSize Returns the size of the payload sent for the first count bets
+// of the batch, compressed if the connection would compress it. The framing
+// of the connection, signature included, is already excluded from maxBytes,
+// since it does not depend on the payload being compressed
+func (b *BatchBuilder) sentSize(batch *Batch, buf []byte, ends []int, count int) (int, error) {
+	prefix := &Batch{Bets: batch.Bets[:count], Row: batch.Row, payload: buf[:ends[count-1]]}
+	b.seal(prefix)
+	if len(prefix.payload) < b.compressionThreshold {
+		return len(prefix.payload), nil
+	}
+	compressed, _, err := protocol.Compress(prefix.payload)
+	if err != nil {
+		return 0, err
+	}
+	return len(compressed), nil
+}
+
+// seal Writes the amount of bets, the hash of the content and the batch ID
+// into the payload of the batch
+func (b *BatchBuilder) seal(batch *Batch) {
+	content := batch.payload[batchHeaderSize:]
+	binary.BigEndian.PutUint32(content[:batchCountSize], uint32(len(batch.Bets)))
+	batch.Hash = sha256.Sum256(content)
+
+	copy(batch.payload[:batchIDSize], batch.ID().encode())
+	copy(batch.payload[batchIDSize:batchHeaderSize], batch.Hash[:])
+}
+
+// unread Leaves a bet for the next batch
+func (b *BatchBuilder) unread(bet Bet, row int64) {
+	b.pending = append(b.pending, pendingBet{bet: bet, row: row})
+}
+
+// nextBet Returns the last bet left out of a batch if any, otherwise reads
+// a new one. The dataset row of the bet is returned as well
+func (b *BatchBuilder) nextBet() (Bet, int64, error) {
+	if last := len(b.pending) - 1; last >= 0 {
+		pending := b.pending[last]
+		b.pending = b.pending[:last]
+		return pending.bet, pending.row, nil
+	}
+	bet, err := b.reader.Next()
+	return bet, b.reader.Rows(), err
+}
@@ -0,0 +1,178 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+const testDataset = `Santiago Lionel,Lorca,30904465,1999-03-17,7574
+Valentina,Vera,30170921,1982-05-22,6053
+Martina,Borges,21073376,1994-09-01,6293
+`
+
+func TestBatchBuilderGroupsBetsByMaxAmount(t *testing.T) {
+	builder := NewBatchBuilder(NewBetReader(strings.NewReader(testDataset), "1"), 0, 2, DefaultBatchMaxBytes)
+
+	var sizes []int
+	for {
+		batch, err := builder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		sizes = append(sizes, len(batch.Bets))
+	}
+	if len(sizes) != 2 || sizes[0] != 2 || sizes[1] != 1 {
+		t.Errorf("expected batches of [2 1] bets, got %v", sizes)
+	}
+}
+
+func TestBatchBuilderNeverExceedsMaxBytes(t *testing.T) {
+	maxBytes := 150
+	builder := NewBatchBuilder(NewBetReader(strings.NewReader(testDataset), "1"), 0, 100, maxBytes)
+
+	total := 0
+	for {
+		batch, err := builder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(batch.Payload()) > maxBytes {
+			t.Errorf("batch of %d bytes exceeds %d bytes", len(batch.Payload()), maxBytes)
+		}
+		total += len(batch.Bets)
+	}
+	if total != 3 {
+		t.Errorf("expected 3 bets, got %d", total)
+	}
+}
+
+func TestBatchBuilderWithBetLargerThanMaxBytesMustFail(t *testing.T) {
+	builder := NewBatchBuilder(NewBetReader(strings.NewReader(testDataset), "1"), 0, 100, 10)
+
+	if _, err := builder.Next(); err == nil {
+		t.Fatal("expected error with bet larger than max bytes")
+	}
+}
+
+func TestBatchBuilderNumbersBatchesAndTracksOffsets(t *testing.T) {
+	reader := NewBetReader(strings.NewReader(testDataset), "1")
+	if err := reader.Skip(1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	builder := NewBatchBuilder(reader, 1, 1, DefaultBatchMaxBytes)
+
+	for _, expected := range []Batch{{Seq: 1, Offset: 2}, {Seq: 2, Offset: 3}} {
+		batch, err := builder.Next()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if batch.Seq != expected.Seq || batch.Offset != expected.Offset {
+			t.Errorf("expected seq %v and offset %v, got seq %v and offset %v",
+				expected.Seq, expected.Offset, batch.Seq, batch.Offset)
+		}
+	}
+}
+
+func TestBatchBuilderIDsAreDeterministic(t *testing.T) {
+	build := func() *Batch {
+		builder := NewBatchBuilder(NewBetReader(strings.NewReader(testDataset), "3"), 5, 2, DefaultBatchMaxBytes)
+		batch, err := builder.Next()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return batch
+	}
+
+	first, second := build(), build()
+	if first.ID() != (BatchID{Agency: 3, Row: 1}) {
+		t.Errorf("unexpected batch ID %+v", first.ID())
+	}
+	if first.ID() != second.ID() || first.Hash != second.Hash {
+		t.Error("expected the same batch to get the same ID and hash")
+	}
+	if !bytes.Equal(first.Payload(), second.Payload()) {
+		t.Error("expected the same batch to get the same payload")
+	}
+}
+
+func TestBatchBuilderIDsDoNotDependOnBatchSize(t *testing.T) {
+	ids := func(maxAmount int) []BatchID {
+		builder := NewBatchBuilder(NewBetReader(strings.NewReader(testDataset), "1"), 0, maxAmount, DefaultBatchMaxBytes)
+		var ids []BatchID
+		for {
+			batch, err := builder.Next()
+			if err == io.EOF {
+				return ids
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			ids = append(ids, batch.ID())
+		}
+	}
+
+	small, large := ids(1), ids(2)
+	if len(small) != 3 || len(large) != 2 {
+		t.Fatalf("expected 3 and 2 batches, got %v and %v", small, large)
+	}
+	if large[1] != small[2] || large[1] != (BatchID{Agency: 1, Row: 3}) {
+		t.Errorf("expected batches starting at row 3 to share ID, got %+v and %+v", large[1], small[2])
+	}
+}
+
+func TestBatchBuilderWithCompressionFitsMoreBets(t *testing.T) {
+	var dataset strings.Builder
+	for i := 0; i < 500; i++ {
+		fmt.Fprintf(&dataset, "Santiago Lionel,Lorca,%d,1999-03-17,%d\n", 30904465+i, i)
+	}
+	maxBytes := 2048
+
+	count := func(compression bool) int {
+		builder := NewBatchBuilder(NewBetReader(strings.NewReader(dataset.String()), "1"), 0, 1000, maxBytes)
+		if compression {
+			builder.SetCompression(0)
+		}
+		batches, total := 0, 0
+		for {
+			batch, err := builder.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			compressed, _, err := protocol.Compress(batch.Payload())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if compression && len(compressed) > maxBytes {
+				t.Errorf("compressed batch of %d bytes exceeds %d bytes", len(compressed), maxBytes)
+			}
+			if batch.Offset != int64(total+len(batch.Bets)) {
+				t.Errorf("expected offset %d, got %d", total+len(batch.Bets), batch.Offset)
+			}
+			batches++
+			total += len(batch.Bets)
+		}
+		if total != 500 {
+			t.Errorf("expected 500 bets, got %d", total)
+		}
+		return batches
+	}
+
+	plain, compressed := count(false), count(true)
+	if compressed*2 > plain {
+		t.Errorf("expected compression to at least halve the %d batches, got %d", plain, compressed)
+	}
+}
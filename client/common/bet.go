@@ -0,0 +1,189 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// DateLayout Layout used to represent a bet birthdate (ISO 8601, YYYY-MM-DD)
+const DateLayout = "2006-01-02"
+
+// Bet A lottery bet registry, mirrors the Bet class used by the server
+type Bet struct {
+	Agency    uint32
+	FirstName string
+	LastName  string
+	Document  string
+	Birthdate time.Time
+	Number    uint32
+}
+
+// NewBet Builds a bet from its fields in text format, as received from the
+// environment or a dataset. agency and number must be passed with integer
+// format, document must only contain digits and birthdate must be passed
+// with format YYYY-MM-DD. An error is returned if some field is invalid
+func NewBet(agency, firstName, lastName, document, birthdate, number string) (Bet, error) {
+	agencyID, err := strconv.ParseUint(strings.TrimSpace(agency), 10, 32)
+	if err != nil {
+		return Bet{}, errors.Wrapf(err, "invalid agency %q", agency)
+	}
+	if strings.TrimSpace(firstName) == "" {
+		return Bet{}, errors.New("first name must not be empty")
+	}
+	if strings.TrimSpace(lastName) == "" {
+		return Bet{}, errors.New("last name must not be empty")
+	}
+	if _, err := strconv.ParseUint(document, 10, 64); err != nil {
+		return Bet{}, errors.Errorf("invalid document %q", document)
+	}
+	date, err := time.Parse(DateLayout, birthdate)
+	if err != nil {
+		return Bet{}, errors.Wrapf(err, "invalid birthdate %q", birthdate)
+	}
+	betNumber, err := strconv.ParseUint(number, 10, 32)
+	if err != nil {
+		return Bet{}, errors.Wrapf(err, "invalid number %q", number)
+	}
+
+	return Bet{
+		Agency:    uint32(agencyID),
+		FirstName: firstName,
+		LastName:  lastName,
+		Document:  document,
+		Birthdate: date,
+		Number:    uint32(betNumber),
+	}, nil
+}
+
+// MarshalBinary Serializes the bet using the wire format shared with the
+// server. Integers are encoded as big endian uint32, strings are prefixed
+// with their length as a big endian uint16 and the birthdate is encoded as
+// a fixed-width ISO date
+func (b *Bet) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := b.encode(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary Parses a bet serialized with MarshalBinary. The whole
+// buffer must be consumed, otherwise an error is returned
+func (b *Bet) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	if err := b.decode(r); err != nil {
+		return err
+	}
+	if r.Len() != 0 {
+		return errors.Errorf("unexpected %d trailing bytes after bet", r.Len())
+	}
+	return nil
+}
+
+// encode Appends the serialized bet to buf
+func (b *Bet) encode(buf *bytes.Buffer) error {
+	writeUint32(buf, b.Agency)
+	for _, field := range []string{b.FirstName, b.LastName, b.Document} {
+		if err := writeString(buf, field); err != nil {
+			return err
+		}
+	}
+	if err := writeDate(buf, b.Birthdate); err != nil {
+		return err
+	}
+	writeUint32(buf, b.Number)
+	return nil
+}
+
+// decode Reads a single serialized bet from r
+func (b *Bet) decode(r *bytes.Reader) error {
+	var err error
+	if b.Agency, err = readUint32(r); err != nil {
+		return errors.Wrap(err, "decode agency")
+	}
+	if b.FirstName, err = readString(r); err != nil {
+		return errors.Wrap(err, "decode first name")
+	}
+	if b.LastName, err = readString(r); err != nil {
+		return errors.Wrap(err, "decode last name")
+	}
+	if b.Document, err = readString(r); err != nil {
+		return errors.Wrap(err, "decode document")
+	}
+
+	date := make([]byte, len(DateLayout))
+	if _, err := io.ReadFull(r, date); err != nil {
+		return errors.Wrap(err, "decode birthdate")
+	}
+	if b.Birthdate, err = time.Parse(DateLayout, string(date)); err != nil {
+		return errors.Wrap(err, "decode birthdate")
+	}
+
+	if b.Number, err = readUint32(r); err != nil {
+		return errors.Wrap(err, "decode number")
+	}
+	return nil
+}
+
+func writeUint32(buf *bytes.Buffer, value uint32) {
+	var raw [4]byte
+	binary.BigEndian.PutUint32(raw[:], value)
+	buf.Write(raw[:])
+}
+
+func readUint32(r io.Reader) (uint32, error) {
+	var raw [4]byte
+	if _, err := io.ReadFull(r, raw[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(raw[:]), nil
+}
+
+// writeDate Appends date formatted with DateLayout. Only years between 0
+// and 9999 are accepted, since other years do not fit its fixed width
+func writeDate(buf *bytes.Buffer, date time.Time) error {
+	if year := date.Year(); year < 0 || year > 9999 {
+		return errors.Errorf("birthdate year %d does not fit in %d characters", year, len(DateLayout))
+	}
+	buf.WriteString(date.Format(DateLayout))
+	return nil
+}
+
+// writeString Appends s prefixed by its length in bytes. Only valid UTF-8
+// strings that fit in an uint16 length are accepted
+func writeString(buf *bytes.Buffer, s string) error {
+	if !utf8.ValidString(s) {
+		return errors.Errorf("string %q is not valid UTF-8", s)
+	}
+	if len(s) > math.MaxUint16 {
+		return errors.Errorf("string of %d bytes exceeds maximum length", len(s))
+	}
+	var raw [2]byte
+	binary.BigEndian.PutUint16(raw[:], uint16(len(s)))
+	buf.Write(raw[:])
+	buf.WriteString(s)
+	return nil
+}
+
+func readString(r io.Reader) (string, error) {
+	var raw [2]byte
+	if _, err := io.ReadFull(r, raw[:]); err != nil {
+		return "", err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(raw[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", err
+	}
+	if !utf8.Valid(data) {
+		return "", errors.New("string is not valid UTF-8")
+	}
+	return string(data), nil
+}
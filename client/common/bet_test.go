@@ -0,0 +1,109 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestBet() Bet {
+	return Bet{
+		Agency:    1,
+		FirstName: "Santiago Lionel",
+		LastName:  "Álvarez",
+		Document:  "30904465",
+		Birthdate: time.Date(1999, time.March, 17, 0, 0, 0, 0, time.UTC),
+		Number:    7574,
+	}
+}
+
+func TestBetMarshalAndUnmarshalKeepsFields(t *testing.T) {
+	bet := newTestBet()
+	data, err := bet.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got Bet
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != bet {
+		t.Errorf("expected %+v, got %+v", bet, got)
+	}
+}
+
+func TestBetUnmarshalWithTrailingBytesMustFail(t *testing.T) {
+	bet := newTestBet()
+	data, _ := bet.MarshalBinary()
+
+	var got Bet
+	if err := got.UnmarshalBinary(append(data, 0)); err == nil {
+		t.Fatal("expected error with trailing bytes")
+	}
+}
+
+func TestBetUnmarshalWithTruncatedDataMustFail(t *testing.T) {
+	bet := newTestBet()
+	data, _ := bet.MarshalBinary()
+
+	var got Bet
+	if err := got.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Fatal("expected error with truncated data")
+	}
+}
+
+func TestBetMarshalWithInvalidUTF8MustFail(t *testing.T) {
+	bet := newTestBet()
+	bet.FirstName = string([]byte{0xff, 0xfe})
+
+	if _, err := bet.MarshalBinary(); err == nil {
+		t.Fatal("expected error with invalid UTF-8 name")
+	}
+}
+
+func TestBetMarshalWithYearOutOfRangeMustFail(t *testing.T) {
+	for _, year := range []int{-1, 10000} {
+		bet := newTestBet()
+		bet.Birthdate = time.Date(year, time.March, 17, 0, 0, 0, 0, time.UTC)
+		if _, err := bet.MarshalBinary(); err == nil {
+			t.Errorf("expected error with birthdate year %d", year)
+		}
+	}
+
+	for _, year := range []int{0, 9999} {
+		bet := newTestBet()
+		bet.Birthdate = time.Date(year, time.March, 17, 0, 0, 0, 0, time.UTC)
+		data, err := bet.MarshalBinary()
+		if err != nil {
+			t.Fatalf("unexpected error with birthdate year %d: %v", year, err)
+		}
+		var got Bet
+		if err := got.UnmarshalBinary(data); err != nil || got != bet {
+			t.Errorf("expected %+v, got %+v (error %v)", bet, got, err)
+		}
+	}
+}
+
+func TestNewBetParsesTextFields(t *testing.T) {
+	bet, err := NewBet("1", "Santiago Lionel", "Álvarez", "30904465", "1999-03-17", "7574")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bet != newTestBet() {
+		t.Errorf("expected %+v, got %+v", newTestBet(), bet)
+	}
+}
+
+func TestNewBetWithInvalidFieldsMustFail(t *testing.T) {
+	cases := map[string][]string{
+		"empty first name": {"1", "", "Lorca", "30904465", "1999-03-17", "7574"},
+		"invalid document": {"1", "Santiago", "Lorca", "30.904.465", "1999-03-17", "7574"},
+		"invalid date":     {"1", "Santiago", "Lorca", "30904465", "17/03/1999", "7574"},
+		"invalid number":   {"1", "Santiago", "Lorca", "30904465", "1999-03-17", "-1"},
+	}
+	for name, f := range cases {
+		if _, err := NewBet(f[0], f[1], f[2], f[3], f[4], f[5]); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
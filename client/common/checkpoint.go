@@ -0,0 +1,88 @@
+package common
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// Checkpoint Progress of the submission of an agency dataset
+type Checkpoint struct {
+	Agency string `json:"agency"`
+	// Seq Sequence number of the next batch to send, which is also the
+	// amount of batches already confirmed by the server
+	Seq uint64 `json:"seq"`
+	// Offset Amount of dataset rows already stored by the server
+	Offset int64 `json:"offset"`
+}
+
+// CheckpointStore Persists the checkpoint of an agency in a small file so
+// a restarted client can resume right after the last confirmed batch
+type CheckpointStore struct {
+	path string
+}
+
+// NewCheckpointStore Initializes a checkpoint store backed by the file
+// located at path
+func NewCheckpointStore(path string) *CheckpointStore {
+	return &CheckpointStore{
+		path: path,
+	}
+}
+
+// Load Reads the checkpoint of the given agency. If no checkpoint was
+// saved yet, an empty checkpoint is returned. An error is returned if the
+// stored checkpoint belongs to another agency
+func (s *CheckpointStore) Load(agency string) (Checkpoint, error) {
+	content, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return Checkpoint{Agency: agency}, nil
+	}
+	if err != nil {
+		return Checkpoint{}, errors.Wrap(err, "read checkpoint")
+	}
+
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(content, &checkpoint); err != nil {
+		return Checkpoint{}, errors.Wrapf(err, "parse checkpoint %s", s.path)
+	}
+	if checkpoint.Agency != agency {
+		return Checkpoint{}, errors.Errorf("checkpoint %s belongs to agency %s", s.path, checkpoint.Agency)
+	}
+	return checkpoint, nil
+}
+
+// Save Persists the checkpoint atomically: it is written to a temporary
+// file in the same directory which then replaces the previous checkpoint,
+// so a crash never leaves a partially written checkpoint behind
+func (s *CheckpointStore) Save(checkpoint Checkpoint) error {
+	content, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "create checkpoint")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "write checkpoint")
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "sync checkpoint")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "close checkpoint")
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return errors.Wrap(err, "replace checkpoint")
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package common
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointStoreLoadWithoutFileReturnsEmptyCheckpoint(t *testing.T) {
+	store := NewCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+
+	checkpoint, err := store.Load("1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if checkpoint != (Checkpoint{Agency: "1"}) {
+		t.Errorf("expected empty checkpoint, got %+v", checkpoint)
+	}
+}
+
+func TestCheckpointStoreSaveAndLoadKeepsProgress(t *testing.T) {
+	dir := t.TempDir()
+	store := NewCheckpointStore(filepath.Join(dir, "checkpoint.json"))
+
+	saved := Checkpoint{Agency: "1", Seq: 12, Offset: 1200}
+	if err := store.Save(saved); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	loaded, err := store.Load("1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loaded != saved {
+		t.Errorf("expected %+v, got %+v", saved, loaded)
+	}
+
+	// No temporary files must be left behind
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("expected only the checkpoint file, got %d files", len(files))
+	}
+}
+
+func TestCheckpointStoreLoadFromAnotherAgencyMustFail(t *testing.T) {
+	store := NewCheckpointStore(filepath.Join(t.TempDir(), "checkpoint.json"))
+	store.Save(Checkpoint{Agency: "2", Seq: 1, Offset: 100})
+
+	if _, err := store.Load("1"); err == nil {
+		t.Fatal("expected error loading checkpoint of another agency")
+	}
+}
@@ -1,71 +1,727 @@
 package common
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
-	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
 )
 
 // ClientConfig Configuration used by the client
 type ClientConfig struct {
-	ID            string
-	ServerAddress string
-	LoopLapse     time.Duration
-	LoopPeriod    time.Duration
+	ID string
+	// ServerAddresses Addresses of the central servers, tried following
+	// the FailoverStrategy until one of them accepts the connection
+	ServerAddresses  []string
+	FailoverStrategy string
+	LoopLapse        time.Duration
+	LoopPeriod       time.Duration
+	BatchMaxAmount   int
+	BatchMaxBytes    int
+	DatasetArchive   string
+	DatasetPath      string
+	WinnersBackoff   BackoffConfig
+	ShutdownTimeout  time.Duration
+	CheckpointPath   string
+	// RejectsPath CSV report of the dataset rows skipped for being invalid.
+	// Rows are skipped without being reported if empty
+	RejectsPath string
+	// PersistentConnection Reuse a single connection for every request
+	// instead of connecting to the server once per message
+	PersistentConnection bool
+	// PipelineWindow Maximum amount of batches sent without waiting for
+	// their confirmation. Only used with a persistent connection
+	PipelineWindow int
+	// DialTimeout Maximum time to wait for a single connection attempt
+	DialTimeout time.Duration
+	// DialMaxAttempts Maximum amount of connection attempts before giving
+	// up. Attempts also stop once the backoff max elapsed time is reached
+	DialMaxAttempts int
+	// DialBackoff Delays between consecutive connection attempts
+	DialBackoff BackoffConfig
+	// ReadTimeout Maximum time to wait for a whole frame from the server
+	ReadTimeout time.Duration
+	// WriteTimeout Maximum time to send a whole frame to the server
+	WriteTimeout time.Duration
+	// MaxFrameSize Maximum payload size accepted in a frame from the server
+	MaxFrameSize uint32
+	// TLS Encryption and authentication of the connections to the server
+	TLS TLSConfig
+	// AuthSecretFile File with the secret shared with the server to sign
+	// every frame. Frames are not signed if empty
+	AuthSecretFile string
+	// Handshake Negotiate the protocol version and features with the
+	// server at the start of every connection
+	Handshake bool
+	// Compression Offer the server to compress payloads of at least
+	// CompressionThreshold bytes. Only used with the handshake enabled
+	Compression          bool
+	CompressionThreshold int
 }
 
 // Client Entity that encapsulates how
 type Client struct {
-	config ClientConfig
-	conn   net.Conn
+	config  ClientConfig
+	conn    *protocol.Conn
+	servers *serverList
+	// features Features negotiated with the server in the last handshake
+	features protocol.Feature
+	// tlsConfig TLS settings loaded from the configured files, if enabled
+	tlsConfig *tls.Config
+	// authSecret Secret loaded from AuthSecretFile, if configured
+	authSecret []byte
+	summary    Summary
 }
 
-var signalChan chan (os.Signal) = make(chan os.Signal, 1)
+// Summary Outcome of the lottery flow of the agency so far
+type Summary struct {
+	// BetsSent Bets confirmed by the server in this run
+	BetsSent int
+	// Rejected Bets of the dataset skipped for being invalid
+	Rejected int
+	// Winners Amount of winners of the agency, once the draw is performed
+	Winners int
+}
 
 // NewClient Initializes a new client receiving the configuration
 // as a parameter
 func NewClient(config ClientConfig) *Client {
-	signal.Notify(signalChan, syscall.SIGTERM)
 	client := &Client{
-		config: config,
+		config:  config,
+		servers: newServerList(config.ServerAddresses, config.FailoverStrategy),
 	}
-	go client.shutdownClient()
 	return client
 }
 
-// CreateClientSocket Initializes client socket. In case of
-// failure, error is printed in stdout/stderr and exit 1
-// is returned
-func (c *Client) createClientSocket() error {
-	conn, err := net.Dial("tcp", c.config.ServerAddress)
+// CreateClientSocket Initializes client socket. Every attempt tries each
+// configured server following the failover strategy. Failed attempts are
+// retried following the dial retry policy, so a client started before the
+// server is listening keeps trying. If every attempt fails, the error is
+// printed in stdout/stderr and returned. If the handshake is enabled, it is
+// performed right after connecting, and a server refusing it or answering
+// with an invalid signature is not retried.
+// With TLS enabled, connections are encrypted and the server certificate
+// verified. Dialing is aborted if ctx is cancelled
+func (c *Client) createClientSocket(ctx context.Context) error {
+	if c.config.TLS.Enabled && c.tlsConfig == nil {
+		tlsConfig, err := c.config.TLS.load()
+		if err != nil {
+			log.Errorf("action: load_tls | result: fail | client_id: %v | error: %v",
+				c.config.ID,
+				err,
+			)
+			return err
+		}
+		c.tlsConfig = tlsConfig
+	}
+	if c.config.AuthSecretFile != "" && c.authSecret == nil {
+		secret, err := loadAuthSecret(c.config.AuthSecretFile)
+		if err != nil {
+			log.Errorf("action: load_auth_secret | result: fail | client_id: %v | error: %v",
+				c.config.ID,
+				err,
+			)
+			return err
+		}
+		c.authSecret = secret
+	}
+
+	backoff := NewBackoff(c.config.DialBackoff)
+
+	for attempt := 1; ; attempt++ {
+		conn, err := c.dialServers(ctx)
+		if err == nil {
+			log.Debugf("action: connect | result: success | client_id: %v | server: %v | attempts: %v",
+				c.config.ID,
+				conn.RemoteAddr(),
+				attempt,
+			)
+			c.conn = protocol.NewConn(conn)
+			c.conn.SetTimeouts(c.config.ReadTimeout, c.config.WriteTimeout)
+			if c.config.MaxFrameSize > 0 {
+				c.conn.SetMaxFrameSize(c.config.MaxFrameSize)
+			}
+			if c.authSecret != nil {
+				c.conn.SetSigningKey(c.authSecret)
+			}
+			if !c.config.Handshake {
+				return nil
+			}
+			if err = c.handshake(ctx); err == nil {
+				return nil
+			}
+			c.closeClientSocket()
+			if !retryable(err) {
+				return err
+			}
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		delay, ok := backoff.Next()
+		if !ok || attempt >= c.config.DialMaxAttempts {
+			log.Errorf(
+				"action: connect | result: fail | client_id: %v | attempts: %v | error: %v",
+				c.config.ID,
+				attempt,
+				err,
+			)
+			return errors.Wrapf(err, "connect after %d attempts", attempt)
+		}
+		log.Infof("action: connect | result: retry | client_id: %v | attempt: %v | delay: %v | error: %v",
+			c.config.ID,
+			attempt,
+			delay,
+			err,
+		)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// dialServers Tries to connect to every server, in the order given by the
+// failover strategy, until one of them accepts the connection. Host names
+// are resolved on every call. The error of the last server is returned if
+// none of them accepts the connection
+func (c *Client) dialServers(ctx context.Context) (net.Conn, error) {
+	if len(c.config.ServerAddresses) == 0 {
+		return nil, errors.New("no server addresses configured")
+	}
+
+	var dialer dialer = &net.Dialer{Timeout: c.config.DialTimeout}
+	if c.tlsConfig != nil {
+		// The timeout covers the TLS handshake too
+		dialer = &tls.Dialer{
+			NetDialer: &net.Dialer{Timeout: c.config.DialTimeout},
+			Config:    c.tlsConfig,
+		}
+	}
+
+	var err error
+	for _, index := range c.servers.candidates() {
+		address := c.config.ServerAddresses[index]
+		var conn net.Conn
+		if conn, err = dialer.DialContext(ctx, "tcp", address); err == nil {
+			c.servers.markHealthy(index)
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		log.Debugf("action: connect | result: fail | client_id: %v | server: %v | error: %v",
+			c.config.ID,
+			address,
+			err,
+		)
+	}
+	return nil, err
+}
+
+// dialer Establishes plain or TLS connections to the server
+type dialer interface {
+	DialContext(ctx context.Context, network string, address string) (net.Conn, error)
+}
+
+// closeClientSocket Closes the client socket if it is open
+func (c *Client) closeClientSocket() {
+	if c.conn == nil {
+		return
+	}
+	if err := c.conn.Close(); err != nil {
+		log.Errorf("action: close_connection | result: fail | client_id: %v | error: %v",
+			c.config.ID,
+			err,
+		)
+	} else {
+		log.Debugf("action: close_connection | result: success | client_id: %v", c.config.ID)
+	}
+	c.conn = nil
+}
+
+// Summary Returns the outcome of the lottery flow of the agency so far
+func (c *Client) Summary() Summary {
+	return c.summary
+}
+
+// Close Releases the connection kept open between requests, if any
+func (c *Client) Close() {
+	c.closeClientSocket()
+}
+
+// request Sends a message to the server and waits for its reply. When the
+// client uses a persistent connection, it is reused across requests and only
+// re-established after a failure. A request that failed over a reused
+// connection, timed out or got a corrupted reply is sent once again over a
+// new connection.
+// Otherwise a new connection is created for every request
+func (c *Client) request(ctx context.Context, msgType protocol.MessageType, payload []byte) (protocol.MessageType, []byte, error) {
+	reused := c.conn != nil
+	retried := false
+	for {
+		if c.conn == nil {
+			if err := c.createClientSocket(ctx); err != nil {
+				return 0, nil, err
+			}
+		}
+
+		replyType, reply, err := c.exchange(ctx, msgType, payload)
+		if err != nil || !c.config.PersistentConnection {
+			c.closeClientSocket()
+		}
+		if err == nil || ctx.Err() != nil || retried || !retryable(err) {
+			return replyType, reply, err
+		}
+		if !reused && !protocol.IsTimeout(err) && !protocol.IsChecksumMismatch(err) {
+			return replyType, reply, err
+		}
+
+		log.Infof("action: reconnect | result: in_progress | client_id: %v | error: %v",
+			c.config.ID,
+			err,
+		)
+		retried = true
+	}
+}
+
+// retryable Returns whether a request that failed with err may succeed if
+// it is sent again over a new connection. A server that answers with frames
+// larger than allowed is considered faulty, a frame with an invalid
+// signature is not trusted and a refused handshake is refused again, so
+// none of them is retried
+func retryable(err error) bool {
+	return !protocol.IsFrameTooLarge(err) &&
+		!protocol.IsSignatureInvalid(err) &&
+		!protocol.IsHandshakeRejected(err)
+}
+
+// exchange Writes a frame in the current connection and reads the reply
+func (c *Client) exchange(ctx context.Context, msgType protocol.MessageType, payload []byte) (protocol.MessageType, []byte, error) {
+	if err := c.conn.WriteFrame(ctx, msgType, payload); err != nil {
+		return 0, nil, err
+	}
+	return c.readFrame(ctx)
+}
+
+// readFrame Reads a frame from the current connection, logging the frames
+// that arrive corrupted
+func (c *Client) readFrame(ctx context.Context) (protocol.MessageType, []byte, error) {
+	msgType, payload, err := c.conn.ReadFrame(ctx)
+	if protocol.IsChecksumMismatch(err) {
+		log.Errorf("action: checksum | result: fail | client_id: %v | error: %v",
+			c.config.ID,
+			err,
+		)
+	}
+	if protocol.IsSignatureInvalid(err) {
+		log.Errorf("action: signature | result: fail | client_id: %v | error: %v",
+			c.config.ID,
+			err,
+		)
+	}
+	return msgType, payload, err
+}
+
+// closeDataset Closes the agency dataset
+func (c *Client) closeDataset(dataset io.Closer) {
+	if err := dataset.Close(); err != nil {
+		log.Errorf("action: close_dataset | result: fail | client_id: %v | error: %v",
+			c.config.ID,
+			err,
+		)
+		return
+	}
+	log.Debugf("action: close_dataset | result: success | client_id: %v", c.config.ID)
+}
+
+// sleep Waits for the given duration. Returns the context error if ctx
+// is cancelled before the duration elapses
+func sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// drainContext Returns a context that outlives ctx by at most timeout.
+// It lets an operation already in progress finish after the client is
+// asked to stop, without blocking the shutdown for longer than timeout
+func drainContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	drainCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-drainCtx.Done():
+			return
+		}
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-drainCtx.Done():
+		}
+	}()
+	return drainCtx, cancel
+}
+
+// sendMessage Sends a message to the server inside a single frame and
+// waits for the server to answer with another one
+func (c *Client) sendMessage(ctx context.Context, msg string) (string, error) {
+	if err := c.conn.WriteFrame(ctx, protocol.MsgEcho, []byte(msg)); err != nil {
+		return "", err
+	}
+	_, reply, err := c.readFrame(ctx)
 	if err != nil {
-		log.Fatalf(
-			"action: connect | result: fail | client_id: %v | error: %v",
+		return "", err
+	}
+	return string(reply), nil
+}
+
+// SendBet Validates a single bet, sends it to the server and waits for its
+// confirmation
+func (c *Client) SendBet(ctx context.Context, bet Bet) error {
+	validator, err := NewBetValidator(c.config.ID)
+	if err != nil {
+		return err
+	}
+	if err := validator.Validate(bet); err != nil {
+		log.Errorf("action: apuesta_rechazada | result: fail | client_id: %v | dni: %v | error: %v",
 			c.config.ID,
+			bet.Document,
 			err,
 		)
+		return err
+	}
+
+	payload, err := bet.MarshalBinary()
+	if err != nil {
+		return err
 	}
-	c.conn = conn
+
+	msgType, reply, err := c.request(ctx, protocol.MsgBet, payload)
+	if err != nil {
+		return err
+	}
+	if err := checkAck(msgType, reply); err != nil {
+		return err
+	}
+
+	log.Infof("action: apuesta_enviada | result: success | dni: %v | numero: %v",
+		bet.Document,
+		bet.Number,
+	)
+	return nil
+}
+
+// SendBatches Reads the bets of the agency dataset and sends them to the
+// server grouped in batches. Every batch is stored by the server as a whole
+// or rejected, in which case sending stops. If a checkpoint is configured,
+// the submission resumes right after the last batch confirmed by the server
+func (c *Client) SendBatches(ctx context.Context) error {
+	checkpoint, err := c.loadCheckpoint()
+	if err != nil {
+		return err
+	}
+
+	file, err := OpenDataset(c.config.DatasetArchive, c.config.DatasetPath)
+	if err != nil {
+		return err
+	}
+	defer c.closeDataset(file)
+
+	reader := NewBetReader(file, c.config.ID)
+	rejects, err := c.validateBets(reader)
+	if err != nil {
+		return err
+	}
+	defer c.closeRejects(rejects)
+	if err := reader.Skip(checkpoint.Offset); err != nil {
+		return err
+	}
+
+	// The framing of the connection and the features negotiated in the
+	// handshake determine how batches are built and sent, so connect first
+	if c.conn == nil {
+		if err := c.createClientSocket(ctx); err != nil {
+			return err
+		}
+	}
+	// BatchMaxBytes caps the whole frame, so the framing is not available
+	// for the batch itself
+	builder := NewBatchBuilder(
+		reader,
+		checkpoint.Seq,
+		c.config.BatchMaxAmount,
+		c.config.BatchMaxBytes-c.conn.FrameOverhead(),
+	)
+	if c.compressing() {
+		builder.SetCompression(c.config.CompressionThreshold)
+	}
+	if c.pipelining() {
+		return c.sendBatchesPipelined(ctx, builder, checkpoint)
+	}
+
+	sent := 0
+	for {
+		// Stop reading new bets once the client is asked to stop
+		if err := ctx.Err(); err != nil {
+			c.logShutdownDrain(checkpoint)
+			return err
+		}
+		batch, err := builder.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := c.sendBatch(ctx, batch); err != nil {
+			if ctx.Err() != nil {
+				c.logShutdownDrain(checkpoint)
+				return ctx.Err()
+			}
+			return err
+		}
+
+		if err := c.confirmBatch(&checkpoint, batch); err != nil {
+			return err
+		}
+		sent += len(batch.Bets)
+	}
+
+	c.logBatchesSent(sent)
+	return nil
+}
+
+// rejectsReport Bets of the dataset skipped for being invalid
+type rejectsReport struct {
+	writer *RejectsWriter
+	count  int
+}
+
+// validateBets Makes reader skip the invalid bets of the dataset. If a
+// rejects report is configured, they are written to it
+func (c *Client) validateBets(reader *BetReader) (*rejectsReport, error) {
+	validator, err := NewBetValidator(c.config.ID)
+	if err != nil {
+		return nil, err
+	}
+	report := &rejectsReport{}
+	if c.config.RejectsPath != "" {
+		if report.writer, err = NewRejectsWriter(c.config.RejectsPath); err != nil {
+			return nil, err
+		}
+	}
+
+	reader.SetValidator(validator, func(line int, record []string, reason error) error {
+		log.Debugf("action: apuesta_rechazada | result: fail | client_id: %v | linea: %v | error: %v",
+			c.config.ID,
+			line,
+			reason,
+		)
+		report.count++
+		if report.writer == nil {
+			return nil
+		}
+		return report.writer.Write(line, record, reason)
+	})
+	return report, nil
+}
+
+// closeRejects Closes the rejects report, logging how many bets of the
+// dataset were rejected
+func (c *Client) closeRejects(report *rejectsReport) {
+	if report.writer != nil {
+		if err := report.writer.Close(); err != nil {
+			log.Errorf("action: close_rejects | result: fail | client_id: %v | error: %v",
+				c.config.ID,
+				err,
+			)
+		}
+	}
+	c.summary.Rejected = report.count
+	log.Infof("action: validar_apuestas | result: success | client_id: %v | rechazadas: %v | reporte: %v",
+		c.config.ID,
+		report.count,
+		c.config.RejectsPath,
+	)
+}
+
+// confirmBatch Moves the checkpoint past a batch confirmed by the server
+func (c *Client) confirmBatch(checkpoint *Checkpoint, batch *Batch) error {
+	checkpoint.Seq = batch.Seq + 1
+	checkpoint.Offset = batch.Offset
+	if err := c.saveCheckpoint(*checkpoint); err != nil {
+		return err
+	}
+	c.summary.BetsSent += len(batch.Bets)
+	log.Debugf("action: batch_enviado | result: success | client_id: %v | batch: %v | cantidad: %v",
+		c.config.ID,
+		batch.Seq,
+		len(batch.Bets),
+	)
 	return nil
 }
 
-// Graceful shutdown of the client
-func (c *Client) shutdownClient() {
-	<-signalChan
-	log.Debugf("action: shutdown_client | result: in_progress | client_id: %v", c.config.ID)
-	c.conn.Close()
-	log.Debugf("action: shutdown_client | result: success | client_id: %v", c.config.ID)
-	os.Exit(0)
+// logBatchesSent Logs the amount of bets sent once the whole dataset was
+// confirmed by the server
+func (c *Client) logBatchesSent(sent int) {
+	log.Infof("action: apuestas_enviadas | result: success | client_id: %v | cantidad: %v",
+		c.config.ID,
+		sent,
+	)
 }
 
-// StartClientLoop Send messages to the client until some time threshold is met
-func (c *Client) StartClientLoop() {
+// sendBatch Sends a batch and waits for the server to confirm that every
+// bet of the batch was stored. Once the batch is being sent, the client
+// waits up to ShutdownTimeout for the confirmation even if ctx is cancelled
+func (c *Client) sendBatch(ctx context.Context, batch *Batch) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	drainCtx, cancel := drainContext(ctx, c.config.ShutdownTimeout)
+	defer cancel()
+
+	msgType, payload, err := c.request(drainCtx, protocol.MsgBatch, batch.Payload())
+	if err != nil {
+		return err
+	}
+	return c.checkBatchAck(msgType, payload, batch)
+}
+
+// checkBatchAck Checks the server answer to a batch
+func (c *Client) checkBatchAck(msgType protocol.MessageType, payload []byte, batch *Batch) error {
+	id, stored, err := c.parseBatchAck(msgType, payload)
+	if err != nil {
+		return err
+	}
+	if id != batch.ID() {
+		return errors.Errorf("expected confirmation of batch %+v, got %+v", batch.ID(), id)
+	}
+	return checkStoredBatch(batch, stored)
+}
+
+// parseBatchAck Parses the server answer to a batch, which starts with the
+// ID of the batch being answered. A batch reported as already stored is
+// considered confirmed, since it was sent again after its previous
+// confirmation got lost. In that case the hash of the batch stored by the
+// server is returned as well, so it can be checked against the batch sent
+func (c *Client) parseBatchAck(msgType protocol.MessageType, payload []byte) (BatchID, []byte, error) {
+	if msgType != protocol.MsgAck && msgType != protocol.MsgDuplicate && msgType != protocol.MsgError {
+		return BatchID{}, nil, errors.Errorf("unexpected message type %v", msgType)
+	}
+	id, rest, err := decodeBatchID(payload)
+	if err != nil {
+		return BatchID{}, nil, err
+	}
+
+	switch msgType {
+	case protocol.MsgDuplicate:
+		if len(rest) != sha256.Size {
+			return BatchID{}, nil, errors.Errorf("duplicate batch %v answered without its hash", id.Row)
+		}
+		log.Infof("action: batch_duplicado | result: success | client_id: %v | batch: %v",
+			c.config.ID,
+			id.Row,
+		)
+		return id, rest, nil
+	case protocol.MsgError:
+		return BatchID{}, nil, errors.Errorf("batch %v rejected by server: %s", id.Row, rest)
+	}
+	return id, nil, nil
+}
+
+// checkStoredBatch Checks that a batch reported as already stored holds the
+// same bets as the batch sent. Otherwise the bets of the batch sent would
+// be lost, which happens if batches are sized differently than when the
+// batch was first stored
+func checkStoredBatch(batch *Batch, stored []byte) error {
+	if stored != nil && !bytes.Equal(stored, batch.Hash[:]) {
+		return errors.Errorf("batch %v already stored by the server with different bets", batch.Row)
+	}
+	return nil
+}
+
+// logShutdownDrain Logs the last batch confirmed by the server when the
+// client is stopped in the middle of a submission
+func (c *Client) logShutdownDrain(checkpoint Checkpoint) {
+	log.Infof("action: shutdown_drain | result: success | client_id: %v | confirmed_batches: %v | offset: %v",
+		c.config.ID,
+		checkpoint.Seq,
+		checkpoint.Offset,
+	)
+}
+
+// loadCheckpoint Loads the checkpoint of the agency. If no checkpoint
+// store is configured the submission always starts from the beginning
+func (c *Client) loadCheckpoint() (Checkpoint, error) {
+	if c.config.CheckpointPath == "" {
+		return Checkpoint{Agency: c.config.ID}, nil
+	}
+	checkpoint, err := NewCheckpointStore(c.config.CheckpointPath).Load(c.config.ID)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	if checkpoint.Seq > 0 {
+		log.Infof("action: resume_batches | result: success | client_id: %v | confirmed_batches: %v | offset: %v",
+			c.config.ID,
+			checkpoint.Seq,
+			checkpoint.Offset,
+		)
+	}
+	return checkpoint, nil
+}
+
+// saveCheckpoint Persists the progress of the submission if a checkpoint
+// store is configured
+func (c *Client) saveCheckpoint(checkpoint Checkpoint) error {
+	if c.config.CheckpointPath == "" {
+		return nil
+	}
+	if err := NewCheckpointStore(c.config.CheckpointPath).Save(checkpoint); err != nil {
+		log.Errorf("action: save_checkpoint | result: fail | client_id: %v | error: %v",
+			c.config.ID,
+			err,
+		)
+		return err
+	}
+	return nil
+}
+
+// checkAck Checks the server answer to a request. An error is returned if
+// the server rejected it or answered with an unexpected message
+func checkAck(msgType protocol.MessageType, payload []byte) error {
+	switch msgType {
+	case protocol.MsgAck:
+		return nil
+	case protocol.MsgError:
+		return errors.Errorf("request rejected by server: %s", payload)
+	default:
+		return errors.Errorf("unexpected message type %v", msgType)
+	}
+}
+
+// StartClientLoop Send messages to the client until some time threshold is
+// met or ctx is cancelled. Returns an error if the server cannot be reached
+// or a message cannot be exchanged
+func (c *Client) StartClientLoop(ctx context.Context) error {
 	// autoincremental msgID to identify every message sent
 	msgID := 1
 
@@ -78,29 +734,32 @@ loop:
 				c.config.ID,
 			)
 			break loop
+		case <-ctx.Done():
+			break loop
 		default:
 		}
 
 		// Create the connection the server in every loop iteration. Send an
-		c.createClientSocket()
+		if err := c.createClientSocket(ctx); err != nil {
+			if ctx.Err() != nil {
+				break loop
+			}
+			return err
+		}
 
-		// TODO: Modify the send to avoid short-write
-		fmt.Fprintf(
-			c.conn,
-			"[CLIENT %v] Message N°%v\n",
-			c.config.ID,
-			msgID,
-		)
-		msg, err := bufio.NewReader(c.conn).ReadString('\n')
+		msg, err := c.sendMessage(ctx, fmt.Sprintf("[CLIENT %v] Message N°%v", c.config.ID, msgID))
 		msgID++
-		c.conn.Close()
+		c.closeClientSocket()
 
+		if ctx.Err() != nil {
+			break loop
+		}
 		if err != nil {
 			log.Errorf("action: receive_message | result: fail | client_id: %v | error: %v",
 				c.config.ID,
 				err,
 			)
-			return
+			return err
 		}
 		log.Infof("action: receive_message | result: success | client_id: %v | msg: %v",
 			c.config.ID,
@@ -108,8 +767,9 @@ loop:
 		)
 
 		// Wait a time between sending one message and the next one
-		time.Sleep(c.config.LoopPeriod)
+		sleep(ctx, c.config.LoopPeriod)
 	}
 
 	log.Infof("action: loop_finished | result: success | client_id: %v", c.config.ID)
+	return nil
 }
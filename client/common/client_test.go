@@ -0,0 +1,389 @@
+package common
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+func TestSendBatchesWaitsForInFlightBatchOnShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	batches := 0
+	server := startTestServer(t, func(msgType protocol.MessageType, payload []byte) (protocol.MessageType, []byte) {
+		batches++
+		if batches == 2 {
+			// Shutdown arrives while the second batch is waiting for its ack
+			cancel()
+			time.Sleep(20 * time.Millisecond)
+		}
+		return ackBatch(msgType, payload)
+	})
+
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.Address},
+		BatchMaxAmount:  1,
+		BatchMaxBytes:   DefaultBatchMaxBytes,
+		DatasetPath:     writeTestDataset(t),
+		ShutdownTimeout: time.Second,
+		CheckpointPath:  checkpointPath,
+	})
+	if err := client.SendBatches(ctx); err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+
+	checkpoint, err := NewCheckpointStore(checkpointPath).Load("1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if checkpoint.Seq != 2 || checkpoint.Offset != 2 {
+		t.Errorf("expected 2 confirmed batches and offset 2, got %+v", checkpoint)
+	}
+}
+
+func TestSendBatchesResumesAfterLastConfirmedBatch(t *testing.T) {
+	received := 0
+	server := startTestServer(t, func(msgType protocol.MessageType, payload []byte) (protocol.MessageType, []byte) {
+		received++
+		return ackBatch(msgType, payload)
+	})
+
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+	NewCheckpointStore(checkpointPath).Save(Checkpoint{Agency: "1", Seq: 2, Offset: 2})
+
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.Address},
+		BatchMaxAmount:  1,
+		BatchMaxBytes:   DefaultBatchMaxBytes,
+		DatasetPath:     writeTestDataset(t),
+		CheckpointPath:  checkpointPath,
+	})
+	if err := client.SendBatches(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if received != 1 {
+		t.Fatalf("expected only the last batch to be sent, got %d batches", received)
+	}
+	checkpoint, _ := NewCheckpointStore(checkpointPath).Load("1")
+	if checkpoint.Seq != 3 || checkpoint.Offset != 3 {
+		t.Errorf("expected 3 confirmed batches and offset 3, got %+v", checkpoint)
+	}
+}
+
+func TestSendBatchesTreatsDuplicateBatchesAsConfirmed(t *testing.T) {
+	server := startTestServer(t, func(_ protocol.MessageType, payload []byte) (protocol.MessageType, []byte) {
+		// The server answers with the ID and the hash of the stored batch
+		return protocol.MsgDuplicate, payload[:batchHeaderSize]
+	})
+
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.Address},
+		BatchMaxAmount:  2,
+		BatchMaxBytes:   DefaultBatchMaxBytes,
+		DatasetPath:     writeTestDataset(t),
+		CheckpointPath:  checkpointPath,
+	})
+	if err := client.SendBatches(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	checkpoint, _ := NewCheckpointStore(checkpointPath).Load("1")
+	if checkpoint.Seq != 2 || checkpoint.Offset != 3 {
+		t.Errorf("expected 2 confirmed batches and offset 3, got %+v", checkpoint)
+	}
+}
+
+func TestSendBatchesFailsWhenDuplicateBatchHoldsOtherBets(t *testing.T) {
+	server := startTestServer(t, func(_ protocol.MessageType, payload []byte) (protocol.MessageType, []byte) {
+		// The batch was stored with other bets, e.g. by a client that sized
+		// batches differently
+		answer := append([]byte{}, payload[:batchHeaderSize]...)
+		answer[batchIDSize] ^= 0xff
+		return protocol.MsgDuplicate, answer
+	})
+
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.Address},
+		BatchMaxAmount:  2,
+		BatchMaxBytes:   DefaultBatchMaxBytes,
+		DatasetPath:     writeTestDataset(t),
+		CheckpointPath:  checkpointPath,
+	})
+	if err := client.SendBatches(context.Background()); err == nil {
+		t.Fatal("expected error when the stored batch holds other bets")
+	}
+
+	checkpoint, _ := NewCheckpointStore(checkpointPath).Load("1")
+	if checkpoint.Offset != 0 {
+		t.Errorf("expected no confirmed rows, got %+v", checkpoint)
+	}
+}
+
+func TestRequestReconnectsAfterServerClosesPersistentConnection(t *testing.T) {
+	server := startTestServer(t, func(protocol.MessageType, []byte) (protocol.MessageType, []byte) {
+		return protocol.MsgAck, nil
+	})
+
+	client := NewClient(ClientConfig{
+		ID:                   "1",
+		ServerAddresses:      []string{server.Address},
+		PersistentConnection: true,
+	})
+	defer client.Close()
+
+	bet := newTestBet()
+	if err := client.SendBet(context.Background(), bet); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Simulate the server dropping the idle connection
+	client.conn.Close()
+
+	if err := client.SendBet(context.Background(), bet); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if server.Connections() != 2 {
+		t.Errorf("expected 2 connections, got %d", server.Connections())
+	}
+}
+
+func TestCreateClientSocketRetriesUntilServerIsListening(t *testing.T) {
+	// Reserve a free port and release it, the server starts listening later
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	time.AfterFunc(50*time.Millisecond, func() {
+		if listener, err := net.Listen("tcp", address); err == nil {
+			t.Cleanup(func() { listener.Close() })
+		}
+	})
+
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{address},
+		DialTimeout:     time.Second,
+		DialMaxAttempts: 100,
+		DialBackoff:     BackoffConfig{InitialDelay: 10 * time.Millisecond, Multiplier: 1},
+	})
+	if err := client.createClientSocket(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client.Close()
+}
+
+func TestCreateClientSocketFailsAfterMaxAttempts(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{address},
+		DialMaxAttempts: 3,
+		DialBackoff:     BackoffConfig{InitialDelay: time.Millisecond, Multiplier: 1},
+	})
+	if err := client.createClientSocket(context.Background()); err == nil {
+		t.Fatal("expected error connecting to a closed port")
+	}
+}
+
+func TestRequestRetriesAfterReadTimeout(t *testing.T) {
+	requests := 0
+	server := startTestServer(t, func(protocol.MessageType, []byte) (protocol.MessageType, []byte) {
+		requests++
+		if requests == 1 {
+			// Hang longer than the client read timeout
+			time.Sleep(150 * time.Millisecond)
+		}
+		return protocol.MsgAck, nil
+	})
+
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.Address},
+		DialMaxAttempts: 1,
+		ReadTimeout:     100 * time.Millisecond,
+	})
+	if err := client.SendBet(context.Background(), newTestBet()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if server.Connections() != 2 {
+		t.Errorf("expected 2 connections, got %d", server.Connections())
+	}
+}
+
+func TestRequestWithOversizedReplyMustFailWithoutRetrying(t *testing.T) {
+	server := startTestServer(t, func(protocol.MessageType, []byte) (protocol.MessageType, []byte) {
+		return protocol.MsgAck, make([]byte, 1024)
+	})
+
+	client := NewClient(ClientConfig{
+		ID:                   "1",
+		ServerAddresses:      []string{server.Address},
+		DialMaxAttempts:      1,
+		MaxFrameSize:         100,
+		PersistentConnection: true,
+	})
+	defer client.Close()
+
+	_, _, err := client.request(context.Background(), protocol.MsgBet, nil)
+	if !protocol.IsFrameTooLarge(err) {
+		t.Fatalf("expected frame too large error, got %v", err)
+	}
+	if client.conn != nil {
+		t.Error("expected connection to be closed")
+	}
+	if server.Connections() != 1 {
+		t.Errorf("expected a single connection, got %d", server.Connections())
+	}
+}
+
+func TestRequestRetriesAfterCorruptedReply(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for connections := 1; ; connections++ {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			framed := protocol.NewConn(conn)
+			if _, _, err := framed.ReadFrame(context.Background()); err != nil {
+				framed.Close()
+				continue
+			}
+			if connections == 1 {
+				// Ack frame whose trailer does not match its checksum
+				conn.Write([]byte{byte(protocol.MsgAck), 0, 0, 0, 0, 0, 0, 0, 0})
+			} else {
+				framed.WriteFrame(context.Background(), protocol.MsgAck, nil)
+			}
+			framed.Close()
+		}
+	}()
+
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{listener.Addr().String()},
+		DialMaxAttempts: 1,
+	})
+	if err := client.SendBet(context.Background(), newTestBet()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSendBatchesSkipsInvalidBetsAndReportsThem(t *testing.T) {
+	stored := 0
+	server := startTestServer(t, func(msgType protocol.MessageType, payload []byte) (protocol.MessageType, []byte) {
+		stored += int(binary.BigEndian.Uint32(payload[batchHeaderSize:]))
+		return ackBatch(msgType, payload)
+	})
+
+	dir := t.TempDir()
+	datasetPath := filepath.Join(dir, "agency-1.csv")
+	dataset := testDataset + "Julian,Paz,25111222,2999-01-01,100\n"
+	if err := ioutil.WriteFile(datasetPath, []byte(dataset), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rejectsPath := filepath.Join(dir, "rejects.csv")
+
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.Address},
+		BatchMaxAmount:  10,
+		BatchMaxBytes:   DefaultBatchMaxBytes,
+		DatasetPath:     datasetPath,
+		RejectsPath:     rejectsPath,
+	})
+	if err := client.SendBatches(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if stored != 3 {
+		t.Errorf("expected 3 bets stored, got %d", stored)
+	}
+	report, err := ioutil.ReadFile(rejectsPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(string(report), "\n4,birthdate 2999-01-01 is in the future,Julian,") {
+		t.Errorf("expected line 4 to be reported, got %q", report)
+	}
+}
+
+func TestSendBatchesFramesNeverExceedBatchMaxBytes(t *testing.T) {
+	server := startTestServer(t, ackBatch)
+
+	// Every bet of the dataset has the same size, so with a budget that
+	// fits 16 bets plus the header but not the trailer, ignoring the trailer
+	// makes frames exceed the budget
+	maxBytes := protocol.HeaderSize + batchHeaderSize + batchCountSize + 16*generatedBetSize(t) + 2
+	client := NewClient(ClientConfig{
+		ID:                   "1",
+		ServerAddresses:      []string{server.Address},
+		DialMaxAttempts:      1,
+		BatchMaxAmount:       1000,
+		BatchMaxBytes:        maxBytes,
+		DatasetPath:          writeLargeTestDataset(t, 200),
+		PersistentConnection: true,
+	})
+	defer client.Close()
+
+	if err := client.SendBatches(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertFullFrames(t, server.MaxFrame(), maxBytes)
+}
+
+// generatedBetSize Returns the size of every serialized bet of a
+// generatedDataset
+func generatedBetSize(t *testing.T) int {
+	bet, err := NewBetReader(&generatedDataset{rows: 1}, "1").Next()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	encoded, err := bet.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return len(encoded)
+}
+
+// assertFullFrames Checks that the largest frame sent, framing included,
+// fills the batch size without exceeding it
+func assertFullFrames(t *testing.T, maxFrame int, maxBytes int) {
+	t.Helper()
+	if maxFrame > maxBytes {
+		t.Errorf("frame of %d bytes on the wire exceeds %d bytes", maxFrame, maxBytes)
+	}
+	// A full batch leaves less room than a bet
+	if maxFrame < maxBytes-64 {
+		t.Errorf("expected full frames of about %d bytes, largest was %d bytes", maxBytes, maxFrame)
+	}
+}
@@ -0,0 +1,170 @@
+package common
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// datasetFields Amount of fields of every dataset row: first name, last
+// name, document, birthdate and number
+const datasetFields = 5
+
+// RejectHandler Receives a dataset row skipped for being invalid, along
+// with its line and the reason. Reading stops if it returns an error. The
+// record is reused by the next read, so it must not be retained
+type RejectHandler func(line int, record []string, reason error) error
+
+// BetReader Reads the bets of an agency dataset one row at a time. Rows
+// are parsed as they are streamed from the underlying reader, so memory
+// usage does not depend on the size of the dataset
+type BetReader struct {
+	agency    string
+	reader    *csv.Reader
+	rows      int64
+	validator *BetValidator
+	reject    RejectHandler
+}
+
+// NewBetReader Initializes a reader of bets in CSV format. Every bet read
+// is assigned to the given agency
+func NewBetReader(r io.Reader, agency string) *BetReader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = datasetFields
+	// Only the slice of fields is reused, the fields kept by bets are not
+	// overwritten by the next read
+	reader.ReuseRecord = true
+	return &BetReader{
+		agency: agency,
+		reader: reader,
+	}
+}
+
+// SetValidator Makes the reader check every bet with validator. Invalid
+// rows, including malformed ones, are skipped and passed to reject instead
+// of failing the whole read
+func (r *BetReader) SetValidator(validator *BetValidator, reject RejectHandler) {
+	r.validator = validator
+	r.reject = reject
+}
+
+// Next Returns the next valid bet of the dataset. io.EOF is returned once
+// every bet has been read. Only invalid rows are passed to the reject
+// handler, errors reading the dataset itself are always returned
+func (r *BetReader) Next() (Bet, error) {
+	for {
+		line, record, invalid, err := r.read()
+		if err != nil {
+			return Bet{}, err
+		}
+		var bet Bet
+		if invalid == nil {
+			bet, invalid = NewBet(r.agency, record[0], record[1], record[2], record[3], record[4])
+		}
+		if invalid == nil && r.validator != nil {
+			invalid = r.validator.Validate(bet)
+		}
+		if invalid == nil {
+			return bet, nil
+		}
+		if r.reject == nil {
+			return Bet{}, errors.Wrapf(invalid, "invalid bet at line %d", line)
+		}
+		if err := r.reject(line, record, invalid); err != nil {
+			return Bet{}, err
+		}
+	}
+}
+
+// read Reads the next row of the dataset. Returns the line where the row
+// starts and its fields. If the reader rejects invalid rows, a malformed
+// row is returned along with the reason instead of failing the read
+func (r *BetReader) read() (int, []string, error, error) {
+	record, err := r.reader.Read()
+	if err == io.EOF {
+		return 0, nil, nil, io.EOF
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) && r.reject != nil {
+		// The row is malformed but the rest of the dataset can still be read
+		r.rows++
+		return parseErr.StartLine, record, parseErr.Err, nil
+	}
+	if err != nil {
+		return 0, nil, nil, errors.Wrap(err, "read dataset")
+	}
+	r.rows++
+
+	line, _ := r.reader.FieldPos(0)
+	return line, record, nil, nil
+}
+
+// Skip Discards the given amount of rows of the dataset. Used to resume a
+// submission after the rows already stored by the server. Malformed rows
+// are skipped as well if the reader rejects invalid rows
+func (r *BetReader) Skip(rows int64) error {
+	for r.rows < rows {
+		_, err := r.reader.Read()
+		var parseErr *csv.ParseError
+		if err != nil && !(errors.As(err, &parseErr) && r.reject != nil) {
+			return errors.Wrapf(err, "skip dataset row %d", r.rows+1)
+		}
+		r.rows++
+	}
+	return nil
+}
+
+// Rows Returns the amount of rows of the dataset read so far
+func (r *BetReader) Rows() int64 {
+	return r.rows
+}
+
+// zipEntry Closes both the opened entry and its archive
+type zipEntry struct {
+	io.ReadCloser
+	archive *zip.ReadCloser
+}
+
+func (e *zipEntry) Close() error {
+	entryErr := e.ReadCloser.Close()
+	if err := e.archive.Close(); err != nil {
+		return err
+	}
+	return entryErr
+}
+
+// OpenDataset Opens an agency dataset for reading. If archive is empty
+// the dataset is read from the file located at path, otherwise path is the
+// name of the entry inside the zip archive that holds the dataset. Entries
+// are decompressed while being read, nothing is extracted to disk
+func OpenDataset(archive string, path string) (io.ReadCloser, error) {
+	if archive == "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "open dataset")
+		}
+		return file, nil
+	}
+
+	zipReader, err := zip.OpenReader(archive)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open dataset archive %s", archive)
+	}
+	for _, file := range zipReader.File {
+		if file.Name != path {
+			continue
+		}
+		entry, err := file.Open()
+		if err != nil {
+			zipReader.Close()
+			return nil, errors.Wrapf(err, "open dataset %s in archive %s", path, archive)
+		}
+		return &zipEntry{ReadCloser: entry, archive: zipReader}, nil
+	}
+
+	zipReader.Close()
+	return nil, errors.Errorf("dataset %s not found in archive %s", path, archive)
+}
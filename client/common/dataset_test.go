@@ -0,0 +1,260 @@
+package common
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func writeTestArchive(t *testing.T, entries map[string]string) string {
+	path := filepath.Join(t.TempDir(), "dataset.zip")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer file.Close()
+
+	writer := zip.NewWriter(file)
+	for name, content := range entries {
+		entry, err := writer.Create(name)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		entry.Write([]byte(content))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+func TestOpenDatasetReadsEntryFromArchive(t *testing.T) {
+	archive := writeTestArchive(t, map[string]string{
+		"agency-1.csv": testDataset,
+		"agency-2.csv": "",
+	})
+
+	dataset, err := OpenDataset(archive, "agency-1.csv")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer dataset.Close()
+
+	content, err := ioutil.ReadAll(dataset)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(content) != testDataset {
+		t.Errorf("expected %q, got %q", testDataset, content)
+	}
+}
+
+func TestOpenDatasetWithMissingEntryMustFail(t *testing.T) {
+	archive := writeTestArchive(t, map[string]string{"agency-1.csv": testDataset})
+
+	if _, err := OpenDataset(archive, "agency-9.csv"); err == nil {
+		t.Fatal("expected error with missing entry")
+	}
+}
+
+func TestBetReaderSkipsInvalidRowsAndReportsThem(t *testing.T) {
+	dataset := `Santiago Lionel,Lorca,30904465,1999-03-17,7574
+Valentina,Vera,3017,1982-05-22,6053
+Martina,Borges,21073376
+Julian,Paz,25111222,1990-13-01,100
+Lucia,Diaz,28333444,1991-02-03,200
+`
+	validator, err := NewBetValidator("1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var lines []int
+	reader := NewBetReader(strings.NewReader(dataset), "1")
+	reader.SetValidator(validator, func(line int, record []string, reason error) error {
+		lines = append(lines, line)
+		return nil
+	})
+
+	var documents []string
+	for {
+		bet, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		documents = append(documents, bet.Document)
+	}
+
+	if len(documents) != 2 || documents[0] != "30904465" || documents[1] != "28333444" {
+		t.Errorf("expected valid bets [30904465 28333444], got %v", documents)
+	}
+	if len(lines) != 3 || lines[0] != 2 || lines[1] != 3 || lines[2] != 4 {
+		t.Errorf("expected rejected lines [2 3 4], got %v", lines)
+	}
+	if reader.Rows() != 5 {
+		t.Errorf("expected 5 rows read, got %d", reader.Rows())
+	}
+}
+
+// failingReader Returns its content and then fails every following read,
+// as a corrupt or truncated archive entry does
+type failingReader struct {
+	content io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.content.Read(p)
+	if err == io.EOF {
+		return n, errors.New("zip: checksum error")
+	}
+	return n, err
+}
+
+func TestBetReaderReturnsReadErrorsInsteadOfRejectingThem(t *testing.T) {
+	validator, err := NewBetValidator("1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rejects := 0
+	reader := NewBetReader(&failingReader{content: strings.NewReader(testDataset)}, "1")
+	reader.SetValidator(validator, func(line int, record []string, reason error) error {
+		// Bounds the test if the read error is rejected over and over
+		if rejects++; rejects > 10 {
+			return errors.New("too many rejected rows")
+		}
+		return nil
+	})
+
+	for {
+		_, err := reader.Next()
+		if err == io.EOF {
+			t.Fatal("expected read error, got EOF")
+		}
+		if err != nil {
+			break
+		}
+	}
+	if rejects != 0 {
+		t.Errorf("expected no rejected rows, got %d", rejects)
+	}
+}
+
+func TestBetReaderSkipsMalformedRowsWhenResuming(t *testing.T) {
+	dataset := "Martina,Borges,21073376\n" + testDataset
+	validator, err := NewBetValidator("1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	reader := NewBetReader(strings.NewReader(dataset), "1")
+	reader.SetValidator(validator, func(int, []string, error) error { return nil })
+	if err := reader.Skip(2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	bet, err := reader.Next()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if bet.Document != "30170921" {
+		t.Errorf("expected bet of the third row, got %+v", bet)
+	}
+}
+
+// generatedDataset Streams a dataset of the given amount of rows without
+// holding it in memory
+type generatedDataset struct {
+	rows    int
+	current int
+	pending []byte
+}
+
+func (d *generatedDataset) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(d.pending) == 0 {
+			if d.current == d.rows {
+				break
+			}
+			d.pending = []byte(fmt.Sprintf("Santiago Lionel,Lorca,%d,1999-03-17,%d\n", 30000000+d.current, d.current%10000))
+			d.current++
+		}
+		copied := copy(p[n:], d.pending)
+		d.pending = d.pending[copied:]
+		n += copied
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func TestBetReaderMemoryDoesNotGrowWithDatasetSize(t *testing.T) {
+	if testing.Short() {
+		t.Skip("reads a large dataset")
+	}
+	reader := NewBetReader(&generatedDataset{rows: 300000}, "1")
+
+	var stats runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&stats)
+	baseline := stats.HeapAlloc
+
+	var peak uint64
+	for rows := 0; ; rows++ {
+		if _, err := reader.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if rows%50000 == 0 {
+			runtime.GC()
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > peak {
+				peak = stats.HeapAlloc
+			}
+		}
+	}
+
+	// The dataset takes around 15 MB, far more than the allowed growth
+	if peak > baseline+1024*1024 {
+		t.Errorf("heap grew from %d to %d bytes while reading the dataset", baseline, peak)
+	}
+}
+
+func BenchmarkBetReader(b *testing.B) {
+	reader := NewBetReader(&generatedDataset{rows: b.N}, "1")
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := reader.Next(); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkBatchBuilder(b *testing.B) {
+	builder := NewBatchBuilder(NewBetReader(&generatedDataset{rows: b.N}, "1"), 0, 100, DefaultBatchMaxBytes)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for bets := 0; bets < b.N; {
+		batch, err := builder.Next()
+		if err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+		bets += len(batch.Bets)
+	}
+}
@@ -0,0 +1,105 @@
+package common
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// handshake Negotiates with the server the protocol version and the
+// features used over the current connection. The client offers its
+// protocol version, agency and the features it supports, and the server
+// answers with the ones both sides will use or refuses the client, in
+// which case a HandshakeRejectedError is returned
+func (c *Client) handshake(ctx context.Context) error {
+	agency, err := c.agency()
+	if err != nil {
+		return err
+	}
+	hello := protocol.Hello{
+		Version:  protocol.Version,
+		Agency:   agency,
+		Features: c.supportedFeatures(),
+	}
+
+	msgType, reply, err := c.exchange(ctx, protocol.MsgHello, hello.Encode())
+	if err != nil {
+		return errors.Wrap(err, "handshake")
+	}
+
+	switch msgType {
+	case protocol.MsgHelloAck:
+	case protocol.MsgHelloReject:
+		log.Errorf("action: handshake | result: fail | client_id: %v | version: %v | reason: %s",
+			c.config.ID,
+			protocol.Version,
+			reply,
+		)
+		return &protocol.HandshakeRejectedError{Reason: string(reply)}
+	default:
+		return errors.Errorf("unexpected message type %v during handshake", msgType)
+	}
+
+	ack, err := protocol.DecodeHelloAck(reply)
+	if err != nil {
+		return err
+	}
+	if ack.Version == 0 || ack.Version > protocol.Version {
+		return errors.Errorf("server negotiated unsupported protocol version %v", ack.Version)
+	}
+	if !hello.Features.Has(ack.Features) {
+		return errors.Errorf("server negotiated features %b, only %b were offered", ack.Features, hello.Features)
+	}
+
+	c.features = ack.Features
+	if c.compressing() {
+		c.conn.SetCompression(c.config.CompressionThreshold)
+	}
+	log.Debugf("action: handshake | result: success | client_id: %v | version: %v | features: %b",
+		c.config.ID,
+		ack.Version,
+		ack.Features,
+	)
+	return nil
+}
+
+// supportedFeatures Returns the features the client offers to the server
+// given its configuration
+func (c *Client) supportedFeatures() protocol.Feature {
+	var features protocol.Feature
+	if c.config.PersistentConnection && c.config.PipelineWindow > 1 {
+		features |= protocol.FeaturePipelining
+	}
+	if c.config.Compression {
+		features |= protocol.FeatureCompression
+	}
+	return features
+}
+
+// compressing Returns whether payloads are compressed over the connection,
+// which requires both sides to agree on it during the handshake
+func (c *Client) compressing() bool {
+	return c.config.Handshake && c.features.Has(protocol.FeatureCompression)
+}
+
+// pipelining Returns whether batches may be pipelined over the connection.
+// Without a handshake the server is assumed to accept it
+func (c *Client) pipelining() bool {
+	if !c.config.PersistentConnection || c.config.PipelineWindow <= 1 {
+		return false
+	}
+	return !c.config.Handshake || c.features.Has(protocol.FeaturePipelining)
+}
+
+// agency Returns the agency ID of the client as a number
+func (c *Client) agency() (uint32, error) {
+	agency, err := strconv.ParseUint(c.config.ID, 10, 32)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid agency %q", c.config.ID)
+	}
+	return uint32(agency), nil
+}
@@ -0,0 +1,164 @@
+package common
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// acceptHandshake Wraps handler answering every handshake with the given
+// negotiated features
+func acceptHandshake(features protocol.Feature, handler testHandler) testHandler {
+	return func(msgType protocol.MessageType, payload []byte) (protocol.MessageType, []byte) {
+		if msgType != protocol.MsgHello {
+			return handler(msgType, payload)
+		}
+		reply := make([]byte, 6)
+		binary.BigEndian.PutUint16(reply[0:2], protocol.Version)
+		binary.BigEndian.PutUint32(reply[2:6], uint32(features))
+		return protocol.MsgHelloAck, reply
+	}
+}
+
+func TestHandshakeSendsVersionAgencyAndFeatures(t *testing.T) {
+	var hello []byte
+	server := startTestServer(t, func(msgType protocol.MessageType, payload []byte) (protocol.MessageType, []byte) {
+		hello = payload
+		return acceptHandshake(protocol.FeaturePipelining, nil)(msgType, payload)
+	})
+
+	client := NewClient(ClientConfig{
+		ID:                   "7",
+		ServerAddresses:      []string{server.Address},
+		DialMaxAttempts:      1,
+		PersistentConnection: true,
+		PipelineWindow:       4,
+		Handshake:            true,
+	})
+	defer client.Close()
+
+	if err := client.createClientSocket(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := protocol.Hello{Version: protocol.Version, Agency: 7, Features: protocol.FeaturePipelining}.Encode()
+	if string(hello) != string(expected) {
+		t.Fatalf("expected hello %v, got %v", expected, hello)
+	}
+	if !client.pipelining() {
+		t.Fatalf("expected pipelining to be negotiated")
+	}
+}
+
+func TestHandshakeWithoutPipeliningSendsBatchesOneAtATime(t *testing.T) {
+	server := startTestServer(t, acceptHandshake(0, ackBatch))
+
+	client := NewClient(ClientConfig{
+		ID:                   "1",
+		ServerAddresses:      []string{server.Address},
+		DialMaxAttempts:      1,
+		BatchMaxAmount:       1,
+		BatchMaxBytes:        DefaultBatchMaxBytes,
+		DatasetPath:          writeTestDataset(t),
+		PersistentConnection: true,
+		PipelineWindow:       4,
+		Handshake:            true,
+	})
+	defer client.Close()
+
+	if err := client.SendBatches(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if client.pipelining() {
+		t.Fatalf("expected pipelining not to be negotiated")
+	}
+	if server.Connections() != 1 {
+		t.Fatalf("expected a single connection, got %v", server.Connections())
+	}
+}
+
+func TestHandshakeRejectedIsNotRetried(t *testing.T) {
+	server := startTestServer(t, func(protocol.MessageType, []byte) (protocol.MessageType, []byte) {
+		return protocol.MsgHelloReject, []byte("unsupported version")
+	})
+
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.Address},
+		DialMaxAttempts: 5,
+		DialBackoff:     BackoffConfig{Multiplier: 1},
+		Handshake:       true,
+	})
+	defer client.Close()
+
+	err := client.createClientSocket(context.Background())
+	if !protocol.IsHandshakeRejected(err) {
+		t.Fatalf("expected handshake rejection, got %v", err)
+	}
+	if server.Connections() != 1 {
+		t.Fatalf("expected a single connection, got %v", server.Connections())
+	}
+}
+
+func TestHandshakeWithCompressionSendsCompressedBatches(t *testing.T) {
+	var dataset strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&dataset, "Santiago Lionel,Lorca,%d,1999-03-17,%d\n", 30904465+i, i)
+	}
+	path := filepath.Join(t.TempDir(), "agency-1.csv")
+	if err := ioutil.WriteFile(path, []byte(dataset.String()), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stored := 0
+	server := startCompressingTestServer(t, 0, acceptHandshake(protocol.FeatureCompression, func(msgType protocol.MessageType, payload []byte) (protocol.MessageType, []byte) {
+		stored += int(binary.BigEndian.Uint32(payload[batchHeaderSize:]))
+		return ackBatch(msgType, payload)
+	}))
+
+	client := NewClient(ClientConfig{
+		ID:                   "1",
+		ServerAddresses:      []string{server.Address},
+		DialMaxAttempts:      1,
+		BatchMaxAmount:       1000,
+		BatchMaxBytes:        2048,
+		DatasetPath:          path,
+		PersistentConnection: true,
+		Handshake:            true,
+		Compression:          true,
+	})
+	defer client.Close()
+
+	if err := client.SendBatches(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !client.compressing() {
+		t.Fatalf("expected compression to be negotiated")
+	}
+	if stored != 200 {
+		t.Fatalf("expected 200 bets stored, got %v", stored)
+	}
+}
+
+func TestStartClientLoopReturnsHandshakeRejection(t *testing.T) {
+	server := startTestServer(t, func(protocol.MessageType, []byte) (protocol.MessageType, []byte) {
+		return protocol.MsgHelloReject, []byte("unsupported version")
+	})
+
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.Address},
+		DialMaxAttempts: 1,
+		LoopLapse:       time.Second,
+		Handshake:       true,
+	})
+	if err := client.StartClientLoop(context.Background()); !protocol.IsHandshakeRejected(err) {
+		t.Fatalf("expected handshake rejection, got %v", err)
+	}
+}
@@ -0,0 +1,145 @@
+package common
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// lotteryState Step of the lottery flow the client is currently on
+type lotteryState int
+
+const (
+	stateSendingBets lotteryState = iota
+	stateNotifyingFinished
+	stateQueryingWinners
+	stateDone
+)
+
+// StartLottery Runs the whole lottery flow of an agency: sends every bet
+// of its dataset, notifies the server that the agency finished and then
+// queries the winners of the agency until the draw is performed. With a
+// persistent connection the whole flow is carried over a single session
+func (c *Client) StartLottery(ctx context.Context) error {
+	defer c.Close()
+
+	var backoff *Backoff
+	for state := stateSendingBets; state != stateDone; {
+		var err error
+		switch state {
+		case stateSendingBets:
+			err = c.SendBatches(ctx)
+			state = stateNotifyingFinished
+		case stateNotifyingFinished:
+			err = c.notifyFinished(ctx)
+			state = stateQueryingWinners
+			backoff = NewBackoff(c.config.WinnersBackoff)
+		case stateQueryingWinners:
+			var ready bool
+			if ready, err = c.queryWinners(ctx); ready {
+				state = stateDone
+			} else if err == nil {
+				// Give the remaining agencies time to finish, waiting longer
+				// on every refused query
+				delay, ok := backoff.Next()
+				if !ok {
+					return errors.Errorf("draw not performed after %v", c.config.WinnersBackoff.MaxElapsed)
+				}
+				err = sleep(ctx, delay)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// notifyFinished Notifies the server that the agency sent all its bets
+func (c *Client) notifyFinished(ctx context.Context) error {
+	payload, err := c.agencyPayload()
+	if err != nil {
+		return err
+	}
+
+	msgType, reply, err := c.request(ctx, protocol.MsgFinished, payload)
+	if err != nil {
+		return err
+	}
+	if err := checkAck(msgType, reply); err != nil {
+		return err
+	}
+	log.Infof("action: notificar_fin | result: success | client_id: %v", c.config.ID)
+	return nil
+}
+
+// queryWinners Asks the server for the winners of the agency. Returns false
+// without error if the draw has not been performed yet
+func (c *Client) queryWinners(ctx context.Context) (bool, error) {
+	payload, err := c.agencyPayload()
+	if err != nil {
+		return false, err
+	}
+
+	msgType, reply, err := c.request(ctx, protocol.MsgWinnersRequest, payload)
+	if err != nil {
+		return false, err
+	}
+
+	switch msgType {
+	case protocol.MsgDrawNotReady:
+		log.Debugf("action: consulta_ganadores | result: in_progress | client_id: %v", c.config.ID)
+		return false, nil
+	case protocol.MsgWinnersResponse:
+		winners, err := decodeWinners(reply)
+		if err != nil {
+			return false, err
+		}
+		c.summary.Winners = len(winners)
+		log.Infof("action: consulta_ganadores | result: success | cant_ganadores: %v | client_id: %v", len(winners), c.config.ID)
+		return true, nil
+	case protocol.MsgError:
+		return false, errors.Errorf("winners query rejected by server: %s", reply)
+	default:
+		return false, errors.Errorf("unexpected message type %v", msgType)
+	}
+}
+
+// agencyPayload Serializes the agency ID as a big endian uint32
+func (c *Client) agencyPayload() ([]byte, error) {
+	agency, err := c.agency()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writeUint32(&buf, agency)
+	return buf.Bytes(), nil
+}
+
+// decodeWinners Parses the documents of the winners: the amount of winners
+// as a big endian uint32 followed by every document as a length-prefixed
+// string
+func decodeWinners(payload []byte) ([]string, error) {
+	r := bytes.NewReader(payload)
+	count, err := readUint32(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode winners count")
+	}
+
+	var winners []string
+	for i := uint32(0); i < count; i++ {
+		document, err := readString(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "decode winner document")
+		}
+		winners = append(winners, document)
+	}
+	if r.Len() != 0 {
+		return nil, errors.Errorf("unexpected %d trailing bytes after winners", r.Len())
+	}
+	return winners, nil
+}
@@ -0,0 +1,79 @@
+package common
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+func TestStartLotteryWaitsForDrawAndQueriesWinners(t *testing.T) {
+	received := make(chan protocol.MessageType, 16)
+	queries := 0
+	server := startTestServer(t, func(msgType protocol.MessageType, payload []byte) (protocol.MessageType, []byte) {
+		received <- msgType
+		switch msgType {
+		case protocol.MsgBatch:
+			return ackBatch(msgType, payload)
+		case protocol.MsgFinished:
+			return protocol.MsgAck, nil
+		}
+		queries++
+		if queries == 1 {
+			return protocol.MsgDrawNotReady, nil
+		}
+		var buf bytes.Buffer
+		writeUint32(&buf, 1)
+		writeString(&buf, "30904465")
+		return protocol.MsgWinnersResponse, buf.Bytes()
+	})
+
+	client := NewClient(ClientConfig{
+		ID:                   "1",
+		ServerAddresses:      []string{server.Address},
+		WinnersBackoff:       BackoffConfig{InitialDelay: time.Millisecond, Multiplier: 2},
+		BatchMaxAmount:       2,
+		BatchMaxBytes:        DefaultBatchMaxBytes,
+		DatasetPath:          writeTestDataset(t),
+		PersistentConnection: true,
+	})
+	if err := client.StartLottery(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	close(received)
+
+	expected := []protocol.MessageType{
+		protocol.MsgBatch,
+		protocol.MsgBatch,
+		protocol.MsgFinished,
+		protocol.MsgWinnersRequest,
+		protocol.MsgWinnersRequest,
+	}
+	var got []protocol.MessageType
+	for msgType := range received {
+		got = append(got, msgType)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected messages %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected messages %v, got %v", expected, got)
+		}
+	}
+	if server.Connections() != 1 {
+		t.Errorf("expected a single connection, got %d", server.Connections())
+	}
+}
+
+func TestDecodeWinnersWithTrailingBytesMustFail(t *testing.T) {
+	var buf bytes.Buffer
+	writeUint32(&buf, 0)
+	buf.WriteByte(0)
+
+	if _, err := decodeWinners(buf.Bytes()); err == nil {
+		t.Fatal("expected error with trailing bytes")
+	}
+}
@@ -0,0 +1,157 @@
+package common
+
+import (
+	"context"
+	"io"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// batchPipeline Batches sent over the persistent connection whose
+// confirmation has not been received yet, in the order they were sent
+type batchPipeline struct {
+	builder    *BatchBuilder
+	window     int
+	inflight   []*Batch
+	confirmed  map[BatchID]bool
+	checkpoint Checkpoint
+	exhausted  bool
+	sent       int
+}
+
+// sendBatchesPipelined Sends the batches of builder keeping up to
+// PipelineWindow batches without confirmation, so the connection is not
+// idle while waiting for the server. Confirmations are matched with their
+// batch by ID, and the checkpoint only moves past a batch once every
+// previous batch was confirmed too. If the connection fails, the batches
+// without confirmation are sent again in order over a new connection
+func (c *Client) sendBatchesPipelined(ctx context.Context, builder *BatchBuilder, checkpoint Checkpoint) error {
+	p := &batchPipeline{
+		builder:    builder,
+		window:     c.config.PipelineWindow,
+		confirmed:  make(map[BatchID]bool),
+		checkpoint: checkpoint,
+	}
+
+	// Batches already sent keep waiting for their confirmation for up to
+	// ShutdownTimeout once ctx is cancelled
+	drainCtx, cancel := drainContext(ctx, c.config.ShutdownTimeout)
+	defer cancel()
+
+	progressed := true
+	for {
+		// Stop reading new bets once the client is asked to stop
+		if ctx.Err() != nil {
+			p.exhausted = true
+		}
+		if p.exhausted && len(p.inflight) == 0 {
+			break
+		}
+
+		retryable, err := c.pipelineStep(ctx, drainCtx, p)
+		if err == nil {
+			progressed = true
+			continue
+		}
+		if drainCtx.Err() != nil {
+			break
+		}
+		// Give up if the last retry did not get any confirmation
+		if !retryable || !progressed {
+			return err
+		}
+
+		c.closeClientSocket()
+		progressed = false
+		log.Infof("action: reenviar_batches | result: in_progress | client_id: %v | pendientes: %v | error: %v",
+			c.config.ID,
+			len(p.inflight),
+			err,
+		)
+	}
+
+	if ctx.Err() != nil {
+		c.logShutdownDrain(p.checkpoint)
+		return ctx.Err()
+	}
+	c.logBatchesSent(p.sent)
+	return nil
+}
+
+// pipelineStep Connects to the server if needed, sending again every batch
+// without confirmation, fills the window with new batches and then waits
+// for the next confirmation. Returns whether the error, if any, comes from
+// the connection and can be retried
+func (c *Client) pipelineStep(ctx context.Context, drainCtx context.Context, p *batchPipeline) (bool, error) {
+	if c.conn == nil {
+		if err := c.createClientSocket(drainCtx); err != nil {
+			return retryable(err), err
+		}
+		for _, batch := range p.inflight {
+			if err := c.conn.WriteFrame(drainCtx, protocol.MsgBatch, batch.Payload()); err != nil {
+				return true, err
+			}
+		}
+	}
+
+	for !p.exhausted && ctx.Err() == nil && len(p.inflight) < p.window {
+		batch, err := p.builder.Next()
+		if err == io.EOF {
+			p.exhausted = true
+			break
+		}
+		if err != nil {
+			return false, err
+		}
+		p.inflight = append(p.inflight, batch)
+		if err := c.conn.WriteFrame(drainCtx, protocol.MsgBatch, batch.Payload()); err != nil {
+			return true, err
+		}
+	}
+	if len(p.inflight) == 0 {
+		return false, nil
+	}
+
+	msgType, payload, err := c.readFrame(drainCtx)
+	if err != nil {
+		return retryable(err), err
+	}
+	id, stored, err := c.parseBatchAck(msgType, payload)
+	if err != nil {
+		return false, err
+	}
+	acked := p.inflightBatch(id)
+	if acked == nil {
+		return false, errors.Errorf("confirmation of unexpected batch %+v", id)
+	}
+	if err := checkStoredBatch(acked, stored); err != nil {
+		return false, err
+	}
+	p.confirmed[id] = true
+
+	// Move the checkpoint past every batch confirmed in order
+	for len(p.inflight) > 0 && p.confirmed[p.inflight[0].ID()] {
+		batch := p.inflight[0]
+		if err := c.confirmBatch(&p.checkpoint, batch); err != nil {
+			return false, err
+		}
+		delete(p.confirmed, batch.ID())
+		p.inflight = p.inflight[1:]
+		p.sent += len(batch.Bets)
+	}
+	return false, nil
+}
+
+// inflightBatch Returns the batch with the given ID waiting for its
+// confirmation, or nil if there is none
+func (p *batchPipeline) inflightBatch(id BatchID) *Batch {
+	for _, batch := range p.inflight {
+		if batch.ID() == id {
+			return batch
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package common
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+func newPipelinedTestClient(t *testing.T, address string, checkpointPath string) *Client {
+	client := NewClient(ClientConfig{
+		ID:                   "1",
+		ServerAddresses:      []string{address},
+		BatchMaxAmount:       1,
+		BatchMaxBytes:        DefaultBatchMaxBytes,
+		DatasetPath:          writeTestDataset(t),
+		CheckpointPath:       checkpointPath,
+		PersistentConnection: true,
+		PipelineWindow:       2,
+	})
+	t.Cleanup(client.Close)
+	return client
+}
+
+func TestSendBatchesPipelinedConfirmsEveryBatch(t *testing.T) {
+	server := startTestServer(t, ackBatch)
+
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+	client := newPipelinedTestClient(t, server.Address, checkpointPath)
+	if err := client.SendBatches(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	checkpoint, _ := NewCheckpointStore(checkpointPath).Load("1")
+	if checkpoint.Seq != 3 || checkpoint.Offset != 3 {
+		t.Errorf("expected 3 confirmed batches and offset 3, got %+v", checkpoint)
+	}
+	if server.Connections() != 1 {
+		t.Errorf("expected a single connection, got %d", server.Connections())
+	}
+}
+
+// peekConn Connection whose unread bytes can be checked without
+// consuming them
+type peekConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *peekConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// pending Returns whether the peer sent bytes not read yet, waiting up to
+// wait for them to arrive
+func (c *peekConn) pending(wait time.Duration) bool {
+	c.Conn.SetReadDeadline(time.Now().Add(wait))
+	defer c.Conn.SetReadDeadline(time.Time{})
+	_, err := c.reader.Peek(1)
+	return err == nil
+}
+
+// startWindowTestServer Starts a test server that reads a whole window of
+// batches before answering them, in reverse order. Fails the test if the
+// client sends more batches than the window before getting an answer. The
+// rows where the received batches start are recorded in received
+func startWindowTestServer(t *testing.T, window int, mu *sync.Mutex, received *[]uint64) string {
+	listener := listenTestServer(t)
+	t.Cleanup(func() { listener.Close() })
+
+	serve := func(conn net.Conn) {
+		peek := &peekConn{Conn: conn, reader: bufio.NewReader(conn)}
+		framed := protocol.NewConn(peek)
+		defer framed.Close()
+		for {
+			var payloads [][]byte
+			for len(payloads) < window {
+				// A client not filling the window never gets an answer
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+				_, payload, err := framed.ReadFrame(ctx)
+				cancel()
+				if err != nil {
+					return
+				}
+				payloads = append(payloads, payload)
+			}
+			if peek.pending(50 * time.Millisecond) {
+				t.Errorf("expected at most %d batches without confirmation", window)
+				return
+			}
+			for i := len(payloads) - 1; i >= 0; i-- {
+				id, _, _ := decodeBatchID(payloads[i])
+				mu.Lock()
+				*received = append(*received, id.Row)
+				mu.Unlock()
+				if err := framed.WriteFrame(context.Background(), protocol.MsgAck, payloads[i][:batchIDSize]); err != nil {
+					return
+				}
+			}
+		}
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestSendBatchesPipelinedFillsWindowAndMatchesAcksOutOfOrder(t *testing.T) {
+	var mu sync.Mutex
+	var received []uint64
+	address := startWindowTestServer(t, 2, &mu, &received)
+
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+	client := NewClient(ClientConfig{
+		ID:                   "1",
+		ServerAddresses:      []string{address},
+		BatchMaxAmount:       1,
+		BatchMaxBytes:        DefaultBatchMaxBytes,
+		DatasetPath:          writeLargeTestDataset(t, 4),
+		CheckpointPath:       checkpointPath,
+		PersistentConnection: true,
+		PipelineWindow:       2,
+	})
+	t.Cleanup(client.Close)
+	if err := client.SendBatches(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []uint64{2, 1, 4, 3}
+	if len(received) != len(expected) {
+		t.Fatalf("expected answered batches %v, got %v", expected, received)
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("expected answered batches %v, got %v", expected, received)
+		}
+	}
+	checkpoint, _ := NewCheckpointStore(checkpointPath).Load("1")
+	if checkpoint.Seq != 4 || checkpoint.Offset != 4 {
+		t.Errorf("expected 4 confirmed batches and offset 4, got %+v", checkpoint)
+	}
+}
+
+func TestSendBatchesPipelinedResendsUnconfirmedBatchesInOrder(t *testing.T) {
+	var received []uint64
+	dropped := false
+	server := startTestServer(t, func(msgType protocol.MessageType, payload []byte) (protocol.MessageType, []byte) {
+		id, _, _ := decodeBatchID(payload)
+		received = append(received, id.Row)
+		// Drop the connection the first time the second batch arrives
+		if id.Row == 2 && !dropped {
+			dropped = true
+			return 0, nil
+		}
+		return ackBatch(msgType, payload)
+	})
+
+	checkpointPath := filepath.Join(t.TempDir(), "checkpoint.json")
+	client := newPipelinedTestClient(t, server.Address, checkpointPath)
+	if err := client.SendBatches(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []uint64{1, 2, 2, 3}
+	if len(received) != len(expected) {
+		t.Fatalf("expected batches %v, got %v", expected, received)
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Fatalf("expected batches %v, got %v", expected, received)
+		}
+	}
+	checkpoint, _ := NewCheckpointStore(checkpointPath).Load("1")
+	if checkpoint.Seq != 3 || checkpoint.Offset != 3 {
+		t.Errorf("expected 3 confirmed batches and offset 3, got %+v", checkpoint)
+	}
+}
+
+func TestSendBatchesPipelinedStopsWhenBatchIsRejected(t *testing.T) {
+	server := startTestServer(t, func(_ protocol.MessageType, payload []byte) (protocol.MessageType, []byte) {
+		return protocol.MsgError, append(payload[:batchIDSize:batchIDSize], "invalid bet"...)
+	})
+
+	client := newPipelinedTestClient(t, server.Address, "")
+	if err := client.SendBatches(context.Background()); err == nil {
+		t.Fatal("expected error when a batch is rejected")
+	}
+}
@@ -0,0 +1,66 @@
+package common
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+// rejectsHeader Columns of the rejects report: the dataset line and the
+// reason of the rejection, followed by the fields of the rejected row
+var rejectsHeader = []string{"linea", "motivo", "nombre", "apellido", "documento", "nacimiento", "numero"}
+
+// RejectsWriter Writes the dataset rows that are not sent to the server to
+// a CSV report. Rows are appended, so the report keeps the rows rejected
+// before a restart; rows after the last checkpoint may be reported twice
+type RejectsWriter struct {
+	file   *os.File
+	writer *csv.Writer
+}
+
+// NewRejectsWriter Opens the rejects report at path, creating it with its
+// header if it does not exist
+func NewRejectsWriter(path string) (*RejectsWriter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, errors.Wrap(err, "open rejects report")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrap(err, "open rejects report")
+	}
+
+	w := &RejectsWriter{file: file, writer: csv.NewWriter(file)}
+	if info.Size() == 0 {
+		if err := w.writer.Write(rejectsHeader); err != nil {
+			file.Close()
+			return nil, errors.Wrap(err, "write rejects report")
+		}
+	}
+	return w, nil
+}
+
+// Write Adds a rejected row to the report
+func (w *RejectsWriter) Write(line int, record []string, reason error) error {
+	row := append([]string{strconv.Itoa(line), reason.Error()}, record...)
+	if err := w.writer.Write(row); err != nil {
+		return errors.Wrap(err, "write rejects report")
+	}
+	// Rejects are rare, so keep the report complete even if the client
+	// is stopped abruptly
+	w.writer.Flush()
+	return errors.Wrap(w.writer.Error(), "write rejects report")
+}
+
+// Close Flushes the report and closes its file
+func (w *RejectsWriter) Close() error {
+	w.writer.Flush()
+	if err := w.writer.Error(); err != nil {
+		w.file.Close()
+		return errors.Wrap(err, "write rejects report")
+	}
+	return w.file.Close()
+}
@@ -0,0 +1,38 @@
+package common
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRejectsWriterAppendsRowsAfterHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rejects.csv")
+
+	for _, line := range []int{2, 7} {
+		writer, err := NewRejectsWriter(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		record := []string{"Valentina", "Vera", "3017", "1982-05-22", "6053"}
+		if err := writer.Write(line, record, errors.New("invalid document")); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "linea,motivo,nombre,apellido,documento,nacimiento,numero\n" +
+		"2,invalid document,Valentina,Vera,3017,1982-05-22,6053\n" +
+		"7,invalid document,Valentina,Vera,3017,1982-05-22,6053\n"
+	if string(content) != expected {
+		t.Errorf("expected report %q, got %q", expected, content)
+	}
+}
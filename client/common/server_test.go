@@ -0,0 +1,170 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// testHandler Builds the answer of the test server to a request. If the
+// returned message type is zero, the connection is closed without answering
+type testHandler func(msgType protocol.MessageType, payload []byte) (protocol.MessageType, []byte)
+
+// testServer Server that answers every request received with a testHandler.
+// Requests are handled one at a time, even across connections
+type testServer struct {
+	Address     string
+	handler     testHandler
+	mu          sync.Mutex
+	connections int
+	// maxFrame Size of the largest frame received, as read from the wire
+	maxFrame int
+}
+
+// countingConn Counts the bytes read from a connection
+type countingConn struct {
+	net.Conn
+	read int
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read += n
+	return n, err
+}
+
+// startTestServer Listens on a random local port and serves every request
+// received in every accepted connection with handler until the test finishes
+func startTestServer(t *testing.T, handler testHandler) *testServer {
+	return serveTestListener(t, listenTestServer(t), nil, handler)
+}
+
+// startCompressingTestServer Starts a test server whose connections
+// compress payloads of at least threshold bytes
+func startCompressingTestServer(t *testing.T, threshold int, handler testHandler) *testServer {
+	return serveTestListener(t, listenTestServer(t), func(conn *protocol.Conn) {
+		conn.SetCompression(threshold)
+	}, handler)
+}
+
+// startSigningTestServer Starts a test server whose connections sign and
+// verify every frame with key
+func startSigningTestServer(t *testing.T, key []byte, handler testHandler) *testServer {
+	return serveTestListener(t, listenTestServer(t), func(conn *protocol.Conn) {
+		conn.SetSigningKey(key)
+	}, handler)
+}
+
+// startTLSTestServer Starts a test server that only accepts TLS connections
+func startTLSTestServer(t *testing.T, config *tls.Config, handler testHandler) *testServer {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return serveTestListener(t, listener, nil, handler)
+}
+
+func listenTestServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return listener
+}
+
+// serveTestListener Serves every connection accepted by listener until the
+// test finishes. Every connection is configured with setup, if given
+func serveTestListener(t *testing.T, listener net.Listener, setup func(*protocol.Conn), handler testHandler) *testServer {
+	t.Cleanup(func() { listener.Close() })
+
+	server := &testServer{Address: listener.Addr().String(), handler: handler}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			server.mu.Lock()
+			server.connections++
+			server.mu.Unlock()
+			counted := &countingConn{Conn: conn}
+			framed := protocol.NewConn(counted)
+			if setup != nil {
+				setup(framed)
+			}
+			go server.serve(framed, counted)
+		}
+	}()
+	return server
+}
+
+// serve Answers the requests of a connection until it is closed
+func (s *testServer) serve(conn *protocol.Conn, counted *countingConn) {
+	defer conn.Close()
+	for {
+		before := counted.read
+		msgType, payload, err := conn.ReadFrame(context.Background())
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if size := counted.read - before; size > s.maxFrame {
+			s.maxFrame = size
+		}
+		replyType, reply := s.handler(msgType, payload)
+		s.mu.Unlock()
+		if replyType == 0 {
+			return
+		}
+		if err := conn.WriteFrame(context.Background(), replyType, reply); err != nil {
+			return
+		}
+	}
+}
+
+// Connections Returns the amount of connections accepted so far
+func (s *testServer) Connections() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.connections
+}
+
+// MaxFrame Returns the size of the largest frame received so far,
+// including its framing
+func (s *testServer) MaxFrame() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maxFrame
+}
+
+func writeTestDataset(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "agency-1.csv")
+	if err := ioutil.WriteFile(path, []byte(testDataset), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+// writeLargeTestDataset Writes a dataset of the given amount of rows
+func writeLargeTestDataset(t *testing.T, rows int) string {
+	path := filepath.Join(t.TempDir(), "agency-1.csv")
+	content, err := ioutil.ReadAll(&generatedDataset{rows: rows})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+// ackBatch Answers a batch confirming it was stored
+func ackBatch(_ protocol.MessageType, payload []byte) (protocol.MessageType, []byte) {
+	return protocol.MsgAck, payload[:batchIDSize]
+}
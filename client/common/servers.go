@@ -0,0 +1,54 @@
+package common
+
+// FailoverOrdered Servers are tried in the configured order, starting
+// with the last server that accepted a connection
+const FailoverOrdered = "ordered"
+
+// FailoverRoundRobin Every new connection starts with the server that
+// follows the last one used
+const FailoverRoundRobin = "round_robin"
+
+// serverList Addresses of the central servers the client may connect to.
+// Addresses are kept as configured, host names included, so they are
+// resolved again on every connection attempt and a server whose IP
+// changes after a restart is still reachable
+type serverList struct {
+	addresses []string
+	strategy  string
+	healthy   int
+}
+
+// newServerList Initializes the list of servers with the given failover
+// strategy
+func newServerList(addresses []string, strategy string) *serverList {
+	return &serverList{
+		addresses: addresses,
+		strategy:  strategy,
+		healthy:   -1,
+	}
+}
+
+// candidates Returns the indexes of the servers in the order they must be
+// tried for a new connection
+func (s *serverList) candidates() []int {
+	first := 0
+	if s.strategy == FailoverRoundRobin {
+		first = (s.healthy + 1) % len(s.addresses)
+	} else if s.healthy >= 0 {
+		first = s.healthy
+	}
+
+	indexes := make([]int, 0, len(s.addresses))
+	indexes = append(indexes, first)
+	for i := range s.addresses {
+		if i != first {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// markHealthy Remembers the server that accepted the last connection
+func (s *serverList) markHealthy(index int) {
+	s.healthy = index
+}
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+func TestServerListOrderedStartsWithLastHealthyServer(t *testing.T) {
+	servers := newServerList([]string{"a", "b", "c"}, FailoverOrdered)
+	assertCandidates(t, servers.candidates(), []int{0, 1, 2})
+
+	servers.markHealthy(1)
+	assertCandidates(t, servers.candidates(), []int{1, 0, 2})
+}
+
+func TestServerListRoundRobinStartsAfterLastServerUsed(t *testing.T) {
+	servers := newServerList([]string{"a", "b", "c"}, FailoverRoundRobin)
+	assertCandidates(t, servers.candidates(), []int{0, 1, 2})
+
+	servers.markHealthy(0)
+	assertCandidates(t, servers.candidates(), []int{1, 0, 2})
+
+	servers.markHealthy(2)
+	assertCandidates(t, servers.candidates(), []int{0, 1, 2})
+}
+
+func TestCreateClientSocketFailsOverToStandbyServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	down := listener.Addr().String()
+	listener.Close()
+	standby := startTestServer(t, ackBatch)
+
+	client := NewClient(ClientConfig{
+		ID:               "1",
+		ServerAddresses:  []string{down, standby.Address},
+		FailoverStrategy: FailoverOrdered,
+		DialMaxAttempts:  1,
+	})
+	defer client.Close()
+
+	if err := client.createClientSocket(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertCandidates(t, client.servers.candidates(), []int{1, 0})
+}
+
+func assertCandidates(t *testing.T, got []int, expected []int) {
+	t.Helper()
+	if len(got) != len(expected) {
+		t.Fatalf("expected candidates %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected candidates %v, got %v", expected, got)
+		}
+	}
+}
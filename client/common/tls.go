@@ -0,0 +1,57 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// TLSConfig Settings used to encrypt the connections to the server. With
+// a client certificate, the agency authenticates itself to the server too
+type TLSConfig struct {
+	Enabled bool
+	// CAFile PEM bundle of the authorities trusted to sign the server
+	// certificate. The system pool is used if empty
+	CAFile string
+	// CertFile and KeyFile PEM certificate and key of the agency, sent to
+	// servers requiring mutual TLS. Both must be set or none
+	CertFile string
+	KeyFile  string
+	// ServerName Name expected in the server certificate. The host of the
+	// server address is used if empty
+	ServerName string
+}
+
+// load Builds the crypto/tls configuration, reading the certificates and
+// key from disk
+func (c TLSConfig) load() (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: c.ServerName,
+	}
+
+	if c.CAFile != "" {
+		bundle, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read CA bundle")
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, errors.Errorf("no certificates found in CA bundle %s", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("client certificate and key must be configured together")
+		}
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate")
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
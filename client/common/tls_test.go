@@ -0,0 +1,186 @@
+package common
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
+)
+
+// testPKI Certificate authority along with a server and a client
+// certificate signed by it, written as PEM files in a temporary directory
+type testPKI struct {
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	pool       *x509.CertPool
+	serverCert tls.Certificate
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	dir := t.TempDir()
+	caKey, caCert := newTestCertificate(t, "ca", nil, nil)
+	serverKey, serverCert := newTestCertificate(t, "central", caKey, caCert)
+	clientKey, clientCert := newTestCertificate(t, "agency-1", caKey, caCert)
+
+	pki := &testPKI{
+		CAFile:   writeTestPEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", caCert.Raw),
+		CertFile: writeTestPEM(t, filepath.Join(dir, "client.pem"), "CERTIFICATE", clientCert.Raw),
+		KeyFile:  writeTestPEM(t, filepath.Join(dir, "client-key.pem"), "EC PRIVATE KEY", marshalTestKey(t, clientKey)),
+		pool:     x509.NewCertPool(),
+		serverCert: tls.Certificate{
+			Certificate: [][]byte{serverCert.Raw},
+			PrivateKey:  serverKey,
+		},
+	}
+	pki.pool.AddCert(caCert)
+	return pki
+}
+
+// serverConfig Returns the TLS configuration of a server requiring every
+// client to present a certificate signed by the test authority
+func (p *testPKI) serverConfig() *tls.Config {
+	return &tls.Config{
+		Certificates: []tls.Certificate{p.serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    p.pool,
+	}
+}
+
+// newTestCertificate Generates a certificate for the given name, valid for
+// the local address. It is self-signed, as a certificate authority, if no
+// parent is given
+func newTestCertificate(t *testing.T, name string, parentKey *ecdsa.PrivateKey, parent *x509.Certificate) (*ecdsa.PrivateKey, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		parent, parentKey = template, key
+	}
+
+	raw, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	certificate, err := x509.ParseCertificate(raw)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return key, certificate
+}
+
+func marshalTestKey(t *testing.T, key *ecdsa.PrivateKey) []byte {
+	raw, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return raw
+}
+
+func writeTestPEM(t *testing.T, path string, blockType string, raw []byte) string {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: raw})
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+func ackRequest(protocol.MessageType, []byte) (protocol.MessageType, []byte) {
+	return protocol.MsgAck, nil
+}
+
+func TestSendBetOverMutualTLS(t *testing.T) {
+	pki := newTestPKI(t)
+	server := startTLSTestServer(t, pki.serverConfig(), ackRequest)
+
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.Address},
+		DialMaxAttempts: 1,
+		TLS: TLSConfig{
+			Enabled:    true,
+			CAFile:     pki.CAFile,
+			CertFile:   pki.CertFile,
+			KeyFile:    pki.KeyFile,
+			ServerName: "central",
+		},
+	})
+	defer client.Close()
+
+	if err := client.SendBet(context.Background(), newTestBet()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSendBetWithoutClientCertificateMustFail(t *testing.T) {
+	pki := newTestPKI(t)
+	server := startTLSTestServer(t, pki.serverConfig(), ackRequest)
+
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.Address},
+		DialMaxAttempts: 1,
+		TLS:             TLSConfig{Enabled: true, CAFile: pki.CAFile},
+	})
+	defer client.Close()
+
+	if err := client.SendBet(context.Background(), newTestBet()); err == nil {
+		t.Fatal("expected error without client certificate")
+	}
+}
+
+func TestCreateClientSocketWithUntrustedServerMustFail(t *testing.T) {
+	pki := newTestPKI(t)
+	server := startTLSTestServer(t, pki.serverConfig(), ackRequest)
+
+	// The client trusts a different authority than the one of the server
+	other := newTestPKI(t)
+	client := NewClient(ClientConfig{
+		ID:              "1",
+		ServerAddresses: []string{server.Address},
+		DialMaxAttempts: 1,
+		TLS: TLSConfig{
+			Enabled:  true,
+			CAFile:   other.CAFile,
+			CertFile: pki.CertFile,
+			KeyFile:  pki.KeyFile,
+		},
+	})
+	defer client.Close()
+
+	if err := client.createClientSocket(context.Background()); err == nil {
+		t.Fatal("expected error connecting to an untrusted server")
+	}
+}
+
+func TestTLSConfigWithCertificateWithoutKeyMustFail(t *testing.T) {
+	pki := newTestPKI(t)
+	if _, err := (TLSConfig{Enabled: true, CertFile: pki.CertFile}).load(); err == nil {
+		t.Fatal("expected error loading a certificate without key")
+	}
+}
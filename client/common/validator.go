@@ -0,0 +1,75 @@
+package common
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// MaxNameLength Maximum amount of characters of a first or last name
+	MaxNameLength = 64
+	// MaxBetNumber Highest number that can be bet in the lottery. Numbers are
+	// unsigned, so the lowest one is always 0
+	MaxBetNumber = 9999
+)
+
+// documentPattern Format of a DNI: 7 or 8 digits, without dots
+var documentPattern = regexp.MustCompile(`^[0-9]{7,8}$`)
+
+// BetValidator Checks that a bet can be accepted by the lottery before it
+// leaves the agency
+type BetValidator struct {
+	agency uint32
+	// now Returns the current time, used to reject birthdates in the future
+	now func() time.Time
+}
+
+// NewBetValidator Initializes a validator of the bets of the given agency
+func NewBetValidator(agency string) (*BetValidator, error) {
+	agencyID, err := strconv.ParseUint(agency, 10, 32)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid agency %q", agency)
+	}
+	return &BetValidator{agency: uint32(agencyID), now: time.Now}, nil
+}
+
+// Validate Returns an error describing the first invalid field of the bet,
+// if any
+func (v *BetValidator) Validate(bet Bet) error {
+	if bet.Agency != v.agency {
+		return errors.Errorf("agency %d does not match client agency %d", bet.Agency, v.agency)
+	}
+	if err := validateName("first name", bet.FirstName); err != nil {
+		return err
+	}
+	if err := validateName("last name", bet.LastName); err != nil {
+		return err
+	}
+	if !documentPattern.MatchString(bet.Document) {
+		return errors.Errorf("invalid document %q, expected 7 or 8 digits", bet.Document)
+	}
+	if bet.Birthdate.After(v.now()) {
+		return errors.Errorf("birthdate %s is in the future", bet.Birthdate.Format(DateLayout))
+	}
+	if bet.Number > MaxBetNumber {
+		return errors.Errorf("number %d out of range [0, %d]", bet.Number, MaxBetNumber)
+	}
+	return nil
+}
+
+// validateName Checks that a name is not blank nor longer than
+// MaxNameLength characters
+func validateName(field string, name string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.Errorf("%s must not be empty", field)
+	}
+	if length := utf8.RuneCountInString(name); length > MaxNameLength {
+		return errors.Errorf("%s of %d characters exceeds %d characters", field, length, MaxNameLength)
+	}
+	return nil
+}
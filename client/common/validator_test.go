@@ -0,0 +1,64 @@
+package common
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBetValidatorAcceptsValidBet(t *testing.T) {
+	validator, err := NewBetValidator("1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := validator.Validate(newTestBet()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestBetValidatorNumberBoundaries(t *testing.T) {
+	validator, err := NewBetValidator("1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := map[uint32]bool{
+		0:                true,
+		MaxBetNumber:     true,
+		MaxBetNumber + 1: false,
+	}
+	for number, valid := range cases {
+		bet := newTestBet()
+		bet.Number = number
+		if err := validator.Validate(bet); (err == nil) != valid {
+			t.Errorf("number %d: expected valid %v, got error %v", number, valid, err)
+		}
+	}
+}
+
+func TestBetValidatorRejectsInvalidFields(t *testing.T) {
+	validator, err := NewBetValidator("1")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	validator.now = func() time.Time {
+		return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	cases := map[string]func(bet *Bet){
+		"another agency":     func(bet *Bet) { bet.Agency = 2 },
+		"blank first name":   func(bet *Bet) { bet.FirstName = "  " },
+		"long last name":     func(bet *Bet) { bet.LastName = strings.Repeat("a", MaxNameLength+1) },
+		"short document":     func(bet *Bet) { bet.Document = "123456" },
+		"long document":      func(bet *Bet) { bet.Document = "123456789" },
+		"future birthdate":   func(bet *Bet) { bet.Birthdate = time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC) },
+		"number above range": func(bet *Bet) { bet.Number = MaxBetNumber + 1 },
+	}
+	for name, invalidate := range cases {
+		bet := newTestBet()
+		invalidate(&bet)
+		if err := validator.Validate(bet); err == nil {
+			t.Errorf("%s: expected validation error", name)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
@@ -11,6 +15,7 @@ import (
 	"github.com/spf13/viper"
 
 	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/common"
+	"github.com/7574-sistemas-distribuidos/docker-compose-init/client/protocol"
 )
 
 // InitConfig Function that uses viper library to parse configuration parameters.
@@ -35,6 +40,66 @@ func InitConfig() (*viper.Viper, error) {
 	v.BindEnv("loop", "period")
 	v.BindEnv("loop", "lapse")
 	v.BindEnv("log", "level")
+	v.BindEnv("server", "addresses")
+	v.BindEnv("server", "failover")
+	v.SetDefault("server.failover", common.FailoverOrdered)
+	v.BindEnv("mode")
+	v.BindEnv("agencies")
+	v.SetDefault("mode", "echo")
+	v.BindEnv("batch", "maxAmount")
+	v.BindEnv("batch", "maxBytes")
+	v.SetDefault("batch.maxAmount", 100)
+	v.SetDefault("batch.maxBytes", common.DefaultBatchMaxBytes)
+	v.BindEnv("dataset", "path")
+	v.BindEnv("dataset", "archive")
+	v.BindEnv("shutdown", "timeout")
+	v.BindEnv("checkpoint", "path")
+	v.BindEnv("rejects", "path")
+	v.BindEnv("connection", "persistent")
+	v.SetDefault("connection.persistent", true)
+	v.BindEnv("pipeline", "window")
+	v.SetDefault("pipeline.window", 1)
+	v.SetDefault("shutdown.timeout", "1s")
+	v.BindEnv("timeouts", "dial")
+	v.BindEnv("timeouts", "read")
+	v.BindEnv("timeouts", "write")
+	v.SetDefault("timeouts.dial", "5s")
+	v.SetDefault("timeouts.read", "30s")
+	v.SetDefault("timeouts.write", "10s")
+	v.BindEnv("protocol", "maxFrameSize")
+	v.SetDefault("protocol.maxFrameSize", protocol.DefaultMaxFrameSize)
+	v.BindEnv("tls", "enabled")
+	v.BindEnv("tls", "ca")
+	v.BindEnv("tls", "cert")
+	v.BindEnv("tls", "key")
+	v.BindEnv("tls", "serverName")
+	v.SetDefault("tls.enabled", false)
+	v.BindEnv("auth", "secret_file")
+	v.BindEnv("protocol", "handshake")
+	v.SetDefault("protocol.handshake", true)
+	v.BindEnv("compression", "enabled")
+	v.BindEnv("compression", "threshold")
+	v.SetDefault("compression.enabled", false)
+	v.SetDefault("compression.threshold", protocol.DefaultCompressionThreshold)
+	v.SetDefault("connect.maxAttempts", 10)
+	v.SetDefault("connect.initialDelay", "200ms")
+	v.SetDefault("connect.multiplier", 2)
+	v.SetDefault("connect.maxDelay", "5s")
+	v.SetDefault("connect.jitter", 0.2)
+	v.SetDefault("connect.maxElapsed", "1m")
+	v.SetDefault("winners.initialDelay", "500ms")
+	v.SetDefault("winners.multiplier", 2)
+	v.SetDefault("winners.maxDelay", "10s")
+	v.SetDefault("winners.jitter", 0.2)
+	v.SetDefault("winners.maxElapsed", "5m")
+
+	// Bet fields are read from the environment variables defined for the
+	// agencies, which do not use the CLI_ prefix
+	v.BindEnv("bet.first_name", "NOMBRE")
+	v.BindEnv("bet.last_name", "APELLIDO")
+	v.BindEnv("bet.document", "DOCUMENTO")
+	v.BindEnv("bet.birthdate", "NACIMIENTO")
+	v.BindEnv("bet.number", "NUMERO")
 
 	// Try to read configuration from config file. If config file
 	// does not exists then ReadInConfig will fail but configuration
@@ -54,9 +119,100 @@ func InitConfig() (*viper.Viper, error) {
 		return nil, errors.Wrapf(err, "Could not parse CLI_LOOP_PERIOD env var as time.Duration.")
 	}
 
+	if failover := v.GetString("server.failover"); failover != common.FailoverOrdered && failover != common.FailoverRoundRobin {
+		return nil, errors.Errorf("Unknown server failover strategy %q.", failover)
+	}
+
+	if v.GetInt("batch.maxAmount") <= 0 {
+		return nil, errors.Errorf("batch.maxAmount must be greater than 0, got %d.", v.GetInt("batch.maxAmount"))
+	}
+	// Every batch carries the framing of the connection, signature included
+	// if frames are signed, and its own header besides the bets
+	overhead := protocol.HeaderSize + protocol.TrailerSize + common.BatchOverhead
+	if v.GetString("auth.secret_file") != "" {
+		overhead += protocol.SignatureSize
+	}
+	if v.GetInt("batch.maxBytes") <= overhead {
+		return nil, errors.Errorf("batch.maxBytes must be greater than %d bytes, the overhead of a batch, got %d.", overhead, v.GetInt("batch.maxBytes"))
+	}
+
+	for _, key := range []string{"dial", "read", "write"} {
+		if _, err := time.ParseDuration(v.GetString("timeouts." + key)); err != nil {
+			return nil, errors.Wrapf(err, "Could not parse CLI_TIMEOUTS_%s env var as time.Duration.", strings.ToUpper(key))
+		}
+	}
+
+	if _, err := time.ParseDuration(v.GetString("shutdown.timeout")); err != nil {
+		return nil, errors.Wrapf(err, "Could not parse CLI_SHUTDOWN_TIMEOUT env var as time.Duration.")
+	}
+
 	return v, nil
 }
 
+// InitServerAddresses Returns the addresses of the central servers. They are
+// read from server.addresses, given as a list in the config file or as a
+// comma separated string in the CLI_SERVER_ADDRESSES env variable. If no
+// list is defined, the single server.address is used
+func InitServerAddresses(v *viper.Viper) []string {
+	var addresses []string
+	for _, value := range v.GetStringSlice("server.addresses") {
+		for _, address := range strings.Split(value, ",") {
+			if address = strings.TrimSpace(address); address != "" {
+				addresses = append(addresses, address)
+			}
+		}
+	}
+	if len(addresses) == 0 && v.GetString("server.address") != "" {
+		addresses = append(addresses, v.GetString("server.address"))
+	}
+	return addresses
+}
+
+// InitBackoffConfig Builds the backoff policy configured under the given
+// prefix of viper keys. Every key can be overridden with the corresponding
+// env variable, e.g. CLI_WINNERS_MAXDELAY for winners.maxDelay. An error is
+// returned if some of the parameters cannot be parsed
+func InitBackoffConfig(v *viper.Viper, prefix string) (common.BackoffConfig, error) {
+	config := common.BackoffConfig{
+		Multiplier: v.GetFloat64(prefix + ".multiplier"),
+		Jitter:     v.GetFloat64(prefix + ".jitter"),
+	}
+
+	durations := map[string]*time.Duration{
+		"initialDelay": &config.InitialDelay,
+		"maxDelay":     &config.MaxDelay,
+		"maxElapsed":   &config.MaxElapsed,
+	}
+	for key, duration := range durations {
+		value, err := time.ParseDuration(v.GetString(prefix + "." + key))
+		if err != nil {
+			return config, errors.Wrapf(err, "Could not parse %s.%s as time.Duration.", prefix, key)
+		}
+		*duration = value
+	}
+
+	if config.Multiplier < 1 {
+		return config, errors.Errorf("%s.multiplier must be at least 1", prefix)
+	}
+	if config.Jitter < 0 || config.Jitter > 1 {
+		return config, errors.Errorf("%s.jitter must be between 0 and 1", prefix)
+	}
+	return config, nil
+}
+
+// InitTLSConfig Builds the TLS settings of the connections to the server.
+// Every key can be overridden with the corresponding env variable, e.g.
+// CLI_TLS_CERT for tls.cert, so each agency uses its own certificate
+func InitTLSConfig(v *viper.Viper) common.TLSConfig {
+	return common.TLSConfig{
+		Enabled:    v.GetBool("tls.enabled"),
+		CAFile:     v.GetString("tls.ca"),
+		CertFile:   v.GetString("tls.cert"),
+		KeyFile:    v.GetString("tls.key"),
+		ServerName: v.GetString("tls.serverName"),
+	}
+}
+
 // InitLogger Receives the log level to be set in logrus as a string. This method
 // parses the string and set the level to the logger. If the level string is not
 // valid an error is returned
@@ -78,11 +234,15 @@ func InitLogger(logLevel string) error {
 // PrintConfig Print all the configuration parameters of the program.
 // For debugging purposes only
 func PrintConfig(v *viper.Viper) {
-	logrus.Infof("action: config | result: success | client_id: %s | server_address: %s | loop_lapse: %v | loop_period: %v | log_level: %s",
+	logrus.Infof("action: config | result: success | client_id: %s | mode: %s | server_addresses: %v | server_failover: %s | loop_lapse: %v | loop_period: %v | batch_max_amount: %v | batch_max_bytes: %v | log_level: %s",
 	    v.GetString("id"),
-	    v.GetString("server.address"),
+	    v.GetString("mode"),
+	    InitServerAddresses(v),
+	    v.GetString("server.failover"),
 	    v.GetDuration("loop.lapse"),
 	    v.GetDuration("loop.period"),
+	    v.GetInt("batch.maxAmount"),
+	    v.GetInt("batch.maxBytes"),
 	    v.GetString("log.level"),
     )
 }
@@ -100,13 +260,220 @@ func main() {
 	// Print program config with debugging purposes
 	PrintConfig(v)
 
+	winnersBackoff, err := InitBackoffConfig(v, "winners")
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	dialBackoff, err := InitBackoffConfig(v, "connect")
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
 	clientConfig := common.ClientConfig{
-		ServerAddress: v.GetString("server.address"),
-		ID:            v.GetString("id"),
-		LoopLapse:     v.GetDuration("loop.lapse"),
-		LoopPeriod:    v.GetDuration("loop.period"),
+		ServerAddresses:      InitServerAddresses(v),
+		FailoverStrategy:     v.GetString("server.failover"),
+		ID:                   v.GetString("id"),
+		LoopLapse:            v.GetDuration("loop.lapse"),
+		LoopPeriod:           v.GetDuration("loop.period"),
+		BatchMaxAmount:       v.GetInt("batch.maxAmount"),
+		BatchMaxBytes:        v.GetInt("batch.maxBytes"),
+		DatasetArchive:       v.GetString("dataset.archive"),
+		DatasetPath:          v.GetString("dataset.path"),
+		WinnersBackoff:       winnersBackoff,
+		ShutdownTimeout:      v.GetDuration("shutdown.timeout"),
+		CheckpointPath:       v.GetString("checkpoint.path"),
+		RejectsPath:          v.GetString("rejects.path"),
+		PersistentConnection: v.GetBool("connection.persistent"),
+		PipelineWindow:       v.GetInt("pipeline.window"),
+		DialTimeout:          v.GetDuration("timeouts.dial"),
+		DialMaxAttempts:      v.GetInt("connect.maxAttempts"),
+		DialBackoff:          dialBackoff,
+		ReadTimeout:          v.GetDuration("timeouts.read"),
+		WriteTimeout:         v.GetDuration("timeouts.write"),
+		MaxFrameSize:         v.GetUint32("protocol.maxFrameSize"),
+		TLS:                  InitTLSConfig(v),
+		AuthSecretFile:       v.GetString("auth.secret_file"),
+		Handshake:            v.GetBool("protocol.handshake"),
+		Compression:          v.GetBool("compression.enabled"),
+		CompressionThreshold: v.GetInt("compression.threshold"),
+	}
+
+	ctx, cancel := InitSignalHandler()
+	defer cancel()
+
+	if v.GetString("mode") == "multi" {
+		runAgencies(ctx, v, clientConfig)
+		return
 	}
 
+	clientConfig = InitAgencyFiles(clientConfig)
 	client := common.NewClient(clientConfig)
-	client.StartClientLoop()
+	err = run(ctx, v, client)
+	client.Close()
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Infof("action: shutdown_client | result: success | client_id: %v", clientConfig.ID)
+			return
+		}
+		log.Fatalf("action: run | result: fail | client_id: %v | mode: %v | error: %v",
+			clientConfig.ID,
+			v.GetString("mode"),
+			err,
+		)
+	}
+}
+
+// agencyIDPlaceholder Placeholder replaced by the agency ID in the paths of
+// the checkpoint, the rejects report, the client certificate, its key and
+// the auth secret
+const agencyIDPlaceholder = "{id}"
+
+// InitAgencyFiles Names the files of the agency after its ID, unless they
+// are configured. Agency datasets are named after the agency, both inside
+// the archive and as plain files. Default files are relative to the working
+// directory, so deployments point them at a persistent volume to keep the
+// checkpoint across redeploys. The paths of the checkpoint, the rejects
+// report, the client certificate, its key and the auth secret may contain
+// an {id} placeholder, replaced by the agency ID so each agency uses its
+// own files and credentials
+func InitAgencyFiles(config common.ClientConfig) common.ClientConfig {
+	config.CheckpointPath = strings.ReplaceAll(config.CheckpointPath, agencyIDPlaceholder, config.ID)
+	config.RejectsPath = strings.ReplaceAll(config.RejectsPath, agencyIDPlaceholder, config.ID)
+	config.TLS.CertFile = strings.ReplaceAll(config.TLS.CertFile, agencyIDPlaceholder, config.ID)
+	config.TLS.KeyFile = strings.ReplaceAll(config.TLS.KeyFile, agencyIDPlaceholder, config.ID)
+	config.AuthSecretFile = strings.ReplaceAll(config.AuthSecretFile, agencyIDPlaceholder, config.ID)
+	if config.DatasetPath == "" {
+		config.DatasetPath = fmt.Sprintf("agency-%s.csv", config.ID)
+	}
+	if config.CheckpointPath == "" {
+		config.CheckpointPath = fmt.Sprintf("checkpoint-agency-%s.json", config.ID)
+	}
+	if config.RejectsPath == "" {
+		config.RejectsPath = fmt.Sprintf("rejects-agency-%s.csv", config.ID)
+	}
+	return config
+}
+
+// InitAgencies Builds the configuration of every agency run by the multi
+// mode. Agencies are read from the agencies key, given as a list in the
+// config file or as a comma separated string in the CLI_AGENCIES env
+// variable. Every agency is given as its ID, optionally followed by a colon
+// and the path of its dataset. The rest of the configuration is shared.
+// Several agencies can't share a checkpoint, a rejects report, a client
+// certificate or an auth secret, so their paths must contain the {id}
+// placeholder when configured
+func InitAgencies(v *viper.Viper, base common.ClientConfig) ([]common.ClientConfig, error) {
+	var configs []common.ClientConfig
+	seen := make(map[string]bool)
+	for _, value := range v.GetStringSlice("agencies") {
+		for _, agency := range strings.Split(value, ",") {
+			if agency = strings.TrimSpace(agency); agency == "" {
+				continue
+			}
+			id, dataset := agency, ""
+			if i := strings.Index(agency, ":"); i >= 0 {
+				id, dataset = strings.TrimSpace(agency[:i]), strings.TrimSpace(agency[i+1:])
+			}
+			if id == "" || seen[id] {
+				return nil, errors.Errorf("Invalid or repeated agency %q.", agency)
+			}
+			seen[id] = true
+
+			config := base
+			config.ID = id
+			config.DatasetPath = dataset
+			configs = append(configs, InitAgencyFiles(config))
+		}
+	}
+	if len(configs) == 0 {
+		return nil, errors.New("No agencies configured for multi mode.")
+	}
+	if len(configs) > 1 {
+		shared := []struct{ key, path string }{
+			{"checkpoint.path", base.CheckpointPath},
+			{"rejects.path", base.RejectsPath},
+			{"tls.cert", base.TLS.CertFile},
+			{"tls.key", base.TLS.KeyFile},
+			{"auth.secret_file", base.AuthSecretFile},
+		}
+		for _, file := range shared {
+			if file.path != "" && !strings.Contains(file.path, agencyIDPlaceholder) {
+				return nil, errors.Errorf("%s must contain the %s placeholder to run several agencies.", file.key, agencyIDPlaceholder)
+			}
+		}
+	}
+	return configs, nil
+}
+
+// runAgencies Runs the lottery flow of every configured agency at the same
+// time in a single process, exiting with an error if some of them failed
+func runAgencies(ctx context.Context, v *viper.Viper, base common.ClientConfig) {
+	configs, err := InitAgencies(v, base)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	if _, err := common.RunAgencies(ctx, configs); err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Infof("action: shutdown_client | result: success | agencias: %v", len(configs))
+			return
+		}
+		log.Fatalf("action: run | result: fail | mode: multi | error: %v", err)
+	}
+}
+
+// InitSignalHandler Returns a context that is cancelled as soon as the
+// program receives SIGTERM or SIGINT, so every blocking operation of the
+// client can return and release its resources before exiting
+func InitSignalHandler() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	go func() {
+		defer signal.Stop(signals)
+		select {
+		case sig := <-signals:
+			log.Infof("action: shutdown_signal | result: success | signal: %v", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// run Executes the flow of the configured client mode until it finishes
+// or ctx is cancelled
+func run(ctx context.Context, v *viper.Viper, client *common.Client) error {
+	switch mode := v.GetString("mode"); mode {
+	case "echo":
+		return client.StartClientLoop(ctx)
+	case "bet":
+		bet, err := common.NewBet(
+			v.GetString("id"),
+			v.GetString("bet.first_name"),
+			v.GetString("bet.last_name"),
+			v.GetString("bet.document"),
+			v.GetString("bet.birthdate"),
+			v.GetString("bet.number"),
+		)
+		if err != nil {
+			return err
+		}
+		if err := client.SendBet(ctx, bet); err != nil {
+			log.Errorf("action: apuesta_enviada | result: fail | client_id: %v | dni: %v | numero: %v | error: %v",
+				v.GetString("id"),
+				bet.Document,
+				bet.Number,
+				err,
+			)
+			return err
+		}
+		return nil
+	case "batch":
+		return client.StartLottery(ctx)
+	default:
+		return errors.Errorf("unknown client mode %q", mode)
+	}
 }
@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// compressedFlag Bit of the message type byte set on frames whose payload
+// is compressed with DEFLATE. Message types never use it
+const compressedFlag = 0x80
+
+// DefaultCompressionThreshold Default minimum payload size compressed once
+// compression is enabled. Smaller payloads, like control messages, are sent
+// as they are
+const DefaultCompressionThreshold = 512
+
+// Compress Compresses payload with DEFLATE, as done by WriteFrame. Returns
+// false if the compressed payload is not smaller than the original, in
+// which case the payload is sent uncompressed
+func Compress(payload []byte) ([]byte, bool, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := w.Write(payload); err != nil {
+		return nil, false, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, false, err
+	}
+	if buf.Len() >= len(payload) {
+		return payload, false, nil
+	}
+	return buf.Bytes(), true, nil
+}
+
+// decompress Decompresses a payload compressed with Compress. A
+// FrameTooLargeError is returned if the original payload is larger than
+// max, without decompressing the rest of it
+func decompress(payload []byte, max uint32) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(payload))
+	defer r.Close()
+
+	decompressed, err := ioutil.ReadAll(io.LimitReader(r, int64(max)+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "decompress frame")
+	}
+	if len(decompressed) > int(max) {
+		return nil, &FrameTooLargeError{Size: uint32(len(decompressed)), Max: max}
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,124 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestWriteFrameCompressesPayloadsAboveThreshold(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	payload := bytes.Repeat([]byte("Santiago Lionel,Lorca,30904465,1999-03-17,7574\n"), 100)
+	go func() {
+		conn := NewConn(left)
+		conn.SetCompression(512)
+		conn.WriteFrame(context.Background(), MsgBatch, payload)
+	}()
+
+	conn := NewConn(right)
+	conn.SetCompression(512)
+	header := make([]byte, HeaderSize)
+	if err := conn.readAll(header); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if header[0] != byte(MsgBatch)|compressedFlag {
+		t.Fatalf("expected compressed batch frame, got type %v", header[0])
+	}
+	compressed := make([]byte, binary.BigEndian.Uint32(header[1:HeaderSize]))
+	if len(compressed) >= len(payload) {
+		t.Fatalf("expected payload of %d bytes to be compressed, got %d bytes", len(payload), len(compressed))
+	}
+	if err := conn.readAll(compressed); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got, err := decompress(compressed, DefaultMaxFrameSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(payload, got) {
+		t.Errorf("decompressed payload does not match the original")
+	}
+}
+
+func TestReadFrameDecompressesPayload(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	payload := bytes.Repeat([]byte("Valentina,Vera,30170921,1982-05-22,6053\n"), 100)
+	go func() {
+		conn := NewConn(left)
+		conn.SetCompression(0)
+		conn.WriteFrame(context.Background(), MsgBatch, payload)
+	}()
+
+	conn := NewConn(right)
+	conn.SetCompression(0)
+	msgType, got, err := conn.ReadFrame(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msgType != MsgBatch || !bytes.Equal(payload, got) {
+		t.Errorf("expected original batch frame, got type %v with %d bytes", msgType, len(got))
+	}
+}
+
+func TestWriteFrameKeepsSmallPayloadsUncompressed(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	go func() {
+		conn := NewConn(left)
+		conn.SetCompression(512)
+		conn.WriteFrame(context.Background(), MsgAck, bytes.Repeat([]byte{0}, 12))
+	}()
+
+	header := make([]byte, HeaderSize)
+	if err := NewConn(right).readAll(header); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if header[0] != byte(MsgAck) {
+		t.Fatalf("expected uncompressed ack frame, got type %v", header[0])
+	}
+}
+
+func TestReadFrameWithCompressionNotEnabledMustFail(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	go func() {
+		conn := NewConn(left)
+		conn.SetCompression(0)
+		conn.WriteFrame(context.Background(), MsgBatch, bytes.Repeat([]byte("a"), 1000))
+	}()
+
+	if _, _, err := NewConn(right).ReadFrame(context.Background()); err == nil {
+		t.Fatal("expected error reading compressed frame")
+	}
+}
+
+func TestReadFrameDecompressedAboveMaxFrameSizeMustFail(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	go func() {
+		conn := NewConn(left)
+		conn.SetCompression(0)
+		conn.WriteFrame(context.Background(), MsgBatch, bytes.Repeat([]byte("a"), 100000))
+	}()
+
+	conn := NewConn(right)
+	conn.SetCompression(0)
+	conn.SetMaxFrameSize(1000)
+	if _, _, err := conn.ReadFrame(context.Background()); !IsFrameTooLarge(err) {
+		t.Fatalf("expected frame too large error, got %v", err)
+	}
+}
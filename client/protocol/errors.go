@@ -0,0 +1,108 @@
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// TimeoutError Returned when a frame could not be written or read before
+// the configured timeout. The connection must not be used afterwards,
+// since the frame may have been partially transferred
+type TimeoutError struct {
+	Op      string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s frame: timeout after %v", e.Op, e.Timeout)
+}
+
+// IsTimeout Returns whether err was caused by a frame timeout
+func IsTimeout(err error) bool {
+	var timeoutErr *TimeoutError
+	return errors.As(err, &timeoutErr)
+}
+
+// timeoutError Replaces err with a TimeoutError if it was caused by the
+// deadline of the operation
+func timeoutError(err error, op string, timeout time.Duration) error {
+	var netErr net.Error
+	if timeout > 0 && errors.As(err, &netErr) && netErr.Timeout() {
+		return &TimeoutError{Op: op, Timeout: timeout}
+	}
+	return err
+}
+
+// FrameTooLargeError Returned when a received frame declares a payload
+// larger than the maximum frame size. The connection is closed, since the
+// rest of the frame is never read
+type FrameTooLargeError struct {
+	Size uint32
+	Max  uint32
+}
+
+func (e *FrameTooLargeError) Error() string {
+	return fmt.Sprintf("read frame: payload of %d bytes exceeds maximum of %d bytes", e.Size, e.Max)
+}
+
+// IsFrameTooLarge Returns whether err was caused by a frame exceeding the
+// maximum frame size
+func IsFrameTooLarge(err error) bool {
+	var tooLargeErr *FrameTooLargeError
+	return errors.As(err, &tooLargeErr)
+}
+
+// ChecksumError Returned when the checksum of a received frame does not
+// match its content, meaning the frame was corrupted in transit. The
+// connection must not be used afterwards, since the frame boundaries may
+// not be reliable anymore
+type ChecksumError struct {
+	Expected uint32
+	Actual   uint32
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("read frame: checksum mismatch, expected %08x but got %08x", e.Expected, e.Actual)
+}
+
+// IsChecksumMismatch Returns whether err was caused by a corrupted frame
+func IsChecksumMismatch(err error) bool {
+	var checksumErr *ChecksumError
+	return errors.As(err, &checksumErr)
+}
+
+// SignatureError Returned when a received frame is not signed with the
+// signing key of the connection, meaning it was forged or tampered with.
+// Sending the request again does not fix it, so it must not be retried
+type SignatureError struct{}
+
+func (e *SignatureError) Error() string {
+	return "read frame: invalid signature"
+}
+
+// IsSignatureInvalid Returns whether err was caused by a frame with an
+// invalid signature
+func IsSignatureInvalid(err error) bool {
+	var signatureErr *SignatureError
+	return errors.As(err, &signatureErr)
+}
+
+// HandshakeRejectedError Returned when the server refuses the handshake of
+// the client, e.g. because it does not support its protocol version
+type HandshakeRejectedError struct {
+	Reason string
+}
+
+func (e *HandshakeRejectedError) Error() string {
+	return fmt.Sprintf("handshake rejected by server: %s", e.Reason)
+}
+
+// IsHandshakeRejected Returns whether err was caused by the server
+// refusing the handshake
+func IsHandshakeRejected(err error) bool {
+	var rejectedErr *HandshakeRejectedError
+	return errors.As(err, &rejectedErr)
+}
@@ -0,0 +1,68 @@
+package protocol
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// Version Version of the protocol implemented by this package
+const Version uint16 = 1
+
+// Feature Optional capability of the protocol, negotiated during the
+// handshake. Features are combined as a bitmask
+type Feature uint32
+
+const (
+	// FeatureCompression Payloads may be compressed
+	FeatureCompression Feature = 1 << iota
+	// FeaturePipelining Several requests may be sent before their answers
+	FeaturePipelining
+)
+
+// Has Returns whether every feature of other is included
+func (f Feature) Has(other Feature) bool {
+	return f&other == other
+}
+
+// helloSize Amount of bytes of a serialized Hello: version as a big endian
+// uint16, agency as a big endian uint32 and features as a big endian uint32
+const helloSize = 2 + 4 + 4
+
+// helloAckSize Amount of bytes of a serialized HelloAck: negotiated version
+// as a big endian uint16 and negotiated features as a big endian uint32
+const helloAckSize = 2 + 4
+
+// Hello First message sent by a client on every connection
+type Hello struct {
+	Version  uint16
+	Agency   uint32
+	Features Feature
+}
+
+// Encode Serializes the hello message
+func (h Hello) Encode() []byte {
+	buf := make([]byte, helloSize)
+	binary.BigEndian.PutUint16(buf[0:2], h.Version)
+	binary.BigEndian.PutUint32(buf[2:6], h.Agency)
+	binary.BigEndian.PutUint32(buf[6:10], uint32(h.Features))
+	return buf
+}
+
+// HelloAck Answer of the server accepting a connection, with the version
+// and features both sides will use
+type HelloAck struct {
+	Version  uint16
+	Features Feature
+}
+
+// DecodeHelloAck Parses the answer of the server accepting a connection
+func DecodeHelloAck(payload []byte) (HelloAck, error) {
+	if len(payload) != helloAckSize {
+		return HelloAck{}, errors.Errorf("invalid hello ack of %d bytes", len(payload))
+	}
+	return HelloAck{
+		Version:  binary.BigEndian.Uint16(payload[0:2]),
+		Features: Feature(binary.BigEndian.Uint32(payload[2:6])),
+	}, nil
+}
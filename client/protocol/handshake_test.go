@@ -0,0 +1,27 @@
+package protocol
+
+import "testing"
+
+func TestDecodeHelloAck(t *testing.T) {
+	ack, err := DecodeHelloAck([]byte{0, 1, 0, 0, 0, 2})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ack.Version != 1 || ack.Features != FeaturePipelining {
+		t.Fatalf("unexpected hello ack %+v", ack)
+	}
+}
+
+func TestDecodeHelloAckInvalidSize(t *testing.T) {
+	if _, err := DecodeHelloAck([]byte{0, 1}); err == nil {
+		t.Fatalf("expected an error")
+	}
+}
+
+func TestHelloEncode(t *testing.T) {
+	hello := Hello{Version: 1, Agency: 3, Features: FeatureCompression | FeaturePipelining}
+	expected := []byte{0, 1, 0, 0, 0, 3, 0, 0, 0, 3}
+	if got := hello.Encode(); string(got) != string(expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
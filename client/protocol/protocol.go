@@ -0,0 +1,315 @@
+package protocol
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// castagnoli Table of the CRC32 Castagnoli polynomial, used to checksum
+// every frame
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// MessageType Identifies the kind of payload carried by a frame
+type MessageType uint8
+
+const (
+	// MsgEcho Plain text message that the server returns untouched
+	MsgEcho MessageType = iota + 1
+	// MsgBet Serialized bet sent by an agency to be stored by the server
+	MsgBet
+	// MsgAck Confirmation sent by the server after processing a request
+	MsgAck
+	// MsgError Rejection sent by the server, the payload describes the reason
+	MsgError
+	// MsgBatch Group of serialized bets that must be stored all together
+	MsgBatch
+	// MsgFinished Notification sent by an agency after sending all its bets
+	MsgFinished
+	// MsgWinnersRequest Query of the winners of an agency
+	MsgWinnersRequest
+	// MsgWinnersResponse Documents of the winners of the queried agency
+	MsgWinnersResponse
+	// MsgDrawNotReady Answer to a winners query received before every
+	// agency finished sending its bets
+	MsgDrawNotReady
+	// MsgDuplicate Answer to a batch whose ID was already stored by the
+	// server, the batch is not stored again. Carries the hash of the batch
+	// stored, which the client compares with the batch it sent
+	MsgDuplicate
+	// MsgHello Handshake sent by the client on every new connection with its
+	// protocol version, agency and supported features
+	MsgHello
+	// MsgHelloAck Handshake answer with the negotiated version and features
+	MsgHelloAck
+	// MsgHelloReject Handshake answer refusing the client, the payload
+	// describes the reason
+	MsgHelloReject
+)
+
+// HeaderSize Amount of bytes used by the frame header: one byte for
+// the message type followed by the payload length as a big endian uint32
+const HeaderSize = 5
+
+// TrailerSize Amount of bytes used by the frame trailer: the CRC32
+// (Castagnoli) checksum of the header and payload as a big endian uint32
+const TrailerSize = 4
+
+// DefaultMaxFrameSize Default maximum payload size accepted by ReadFrame
+const DefaultMaxFrameSize = 1024 * 1024
+
+// Conn Wraps a net.Conn to send and receive length-prefixed frames.
+// Every frame is composed of a fixed-size header followed by the payload,
+// its signature if a signing key is set, and a trailer with the checksum of
+// all of them
+type Conn struct {
+	conn         net.Conn
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+	maxFrameSize uint32
+	closed       bool
+	// compression Whether payloads of at least compressionThreshold bytes
+	// are compressed, and compressed frames are accepted
+	compression          bool
+	compressionThreshold int
+	// signingKey Secret used to sign every frame sent and verify every
+	// frame received, if set
+	signingKey []byte
+}
+
+// NewConn Initializes a framed connection over an already established
+// net.Conn
+func NewConn(conn net.Conn) *Conn {
+	return &Conn{
+		conn:         conn,
+		maxFrameSize: DefaultMaxFrameSize,
+	}
+}
+
+// SetMaxFrameSize Sets the maximum payload size accepted by ReadFrame
+func (c *Conn) SetMaxFrameSize(size uint32) {
+	c.maxFrameSize = size
+}
+
+// SetCompression Enables the compression of payloads of at least threshold
+// bytes. Must only be enabled once both sides negotiated it
+func (c *Conn) SetCompression(threshold int) {
+	c.compression = true
+	c.compressionThreshold = threshold
+}
+
+// SetSigningKey Makes every frame carry an HMAC-SHA256 signature of its
+// header and payload computed with key. Frames received must be signed with
+// the same key. Both sides must set it, since it changes the frame layout
+func (c *Conn) SetSigningKey(key []byte) {
+	c.signingKey = key
+}
+
+// SetTimeouts Sets the maximum time a whole frame may take to be read or
+// written. Zero means no timeout
+func (c *Conn) SetTimeouts(read time.Duration, write time.Duration) {
+	c.readTimeout = read
+	c.writeTimeout = write
+}
+
+// WriteFrame Sends a complete frame with the given type and payload. The
+// write is retried until every byte is sent, avoiding short-writes. If ctx
+// is cancelled the write is interrupted and the context error is returned.
+// If the write timeout elapses a TimeoutError is returned. With compression
+// enabled, large payloads are compressed if that makes them smaller
+func (c *Conn) WriteFrame(ctx context.Context, msgType MessageType, payload []byte) error {
+	typeByte := byte(msgType)
+	if c.compression && len(payload) >= c.compressionThreshold {
+		compressed, ok, err := Compress(payload)
+		if err != nil {
+			return errors.Wrap(err, "compress frame")
+		}
+		if ok {
+			typeByte |= compressedFlag
+			payload = compressed
+		}
+	}
+
+	end := HeaderSize + len(payload)
+	frame := make([]byte, end+c.signatureSize()+TrailerSize)
+	frame[0] = typeByte
+	binary.BigEndian.PutUint32(frame[1:HeaderSize], uint32(len(payload)))
+	copy(frame[HeaderSize:], payload)
+	if c.signingKey != nil {
+		end += copy(frame[end:], sign(c.signingKey, frame[:end]))
+	}
+	binary.BigEndian.PutUint32(frame[end:], checksum(frame[:end]))
+
+	if err := c.conn.SetWriteDeadline(deadline(c.writeTimeout)); err != nil {
+		return errors.Wrap(err, "write frame")
+	}
+	stop := c.watch(ctx)
+	defer stop()
+
+	if err := c.writeAll(frame); err != nil {
+		return operationError(ctx, err, "write", c.writeTimeout)
+	}
+	return nil
+}
+
+// ReadFrame Blocks until a complete frame is received and returns its type
+// and payload. Reads are retried until the whole frame arrives, avoiding
+// short-reads. If ctx is cancelled the read is interrupted and the context
+// error is returned. If the read timeout elapses a TimeoutError is returned.
+// If the frame exceeds the maximum frame size, the connection is closed and
+// a FrameTooLargeError is returned, without allocating the payload. The
+// same applies to compressed payloads once decompressed. If the checksum of
+// the frame does not match its content, a ChecksumError is returned. If the
+// frame is not signed with the signing key, a SignatureError is returned
+func (c *Conn) ReadFrame(ctx context.Context) (MessageType, []byte, error) {
+	if err := c.conn.SetReadDeadline(deadline(c.readTimeout)); err != nil {
+		return 0, nil, errors.Wrap(err, "read frame")
+	}
+	stop := c.watch(ctx)
+	defer stop()
+
+	header := make([]byte, HeaderSize)
+	if err := c.readAll(header); err != nil {
+		return 0, nil, operationError(ctx, err, "read", c.readTimeout)
+	}
+
+	msgType := MessageType(header[0] &^ compressedFlag)
+	compressed := header[0]&compressedFlag != 0
+	length := binary.BigEndian.Uint32(header[1:HeaderSize])
+	if length > c.maxFrameSize {
+		c.Close()
+		return 0, nil, &FrameTooLargeError{Size: length, Max: c.maxFrameSize}
+	}
+
+	signed := HeaderSize + int(length)
+	end := signed + c.signatureSize()
+	frame := make([]byte, end+TrailerSize)
+	copy(frame, header)
+	if err := c.readAll(frame[HeaderSize:]); err != nil {
+		return 0, nil, operationError(ctx, err, "read", c.readTimeout)
+	}
+	expected := binary.BigEndian.Uint32(frame[end:])
+	if actual := checksum(frame[:end]); actual != expected {
+		return 0, nil, &ChecksumError{Expected: expected, Actual: actual}
+	}
+	if c.signingKey != nil && !verify(c.signingKey, frame[:signed], frame[signed:end]) {
+		return 0, nil, &SignatureError{}
+	}
+	payload := frame[HeaderSize:signed]
+
+	if !compressed {
+		return msgType, payload, nil
+	}
+	if !c.compression {
+		return 0, nil, errors.New("read frame: compressed frame received without compression enabled")
+	}
+	payload, err := decompress(payload, c.maxFrameSize)
+	if IsFrameTooLarge(err) {
+		c.Close()
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return msgType, payload, nil
+}
+
+// FrameOverhead Returns the amount of bytes every frame adds to its
+// payload, including the signature if a signing key is set
+func (c *Conn) FrameOverhead() int {
+	return HeaderSize + c.signatureSize() + TrailerSize
+}
+
+// signatureSize Returns the size of the signature carried by every frame
+func (c *Conn) signatureSize() int {
+	if c.signingKey == nil {
+		return 0
+	}
+	return SignatureSize
+}
+
+// checksum Returns the CRC32 (Castagnoli) of the given frame bytes
+func checksum(data []byte) uint32 {
+	return crc32.Checksum(data, castagnoli)
+}
+
+// deadline Returns the deadline of an operation starting now that may take
+// up to timeout. The zero time, meaning no deadline, is returned if timeout
+// is zero
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
+
+// operationError Builds the error returned by a failed read or write: the
+// context error if ctx was cancelled, a TimeoutError if the deadline of
+// the operation elapsed, or the original error otherwise
+func operationError(ctx context.Context, err error, op string, timeout time.Duration) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return timeoutError(err, op, timeout)
+}
+
+// Close Closes the underlying connection. Closing an already closed
+// connection has no effect
+func (c *Conn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
+
+// watch Unblocks any pending read or write on the connection as soon as
+// ctx is cancelled, by moving its deadline to the past. The returned
+// function must be called once the operation finishes
+func (c *Conn) watch(ctx context.Context) func() {
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		select {
+		case <-ctx.Done():
+			c.conn.SetDeadline(time.Unix(1, 0))
+		case <-done:
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// writeAll Writes buf into the connection looping until every byte is sent
+func (c *Conn) writeAll(buf []byte) error {
+	for written := 0; written < len(buf); {
+		n, err := c.conn.Write(buf[written:])
+		if err != nil {
+			return errors.Wrap(err, "write frame")
+		}
+		written += n
+	}
+	return nil
+}
+
+// readAll Fills buf with data from the connection looping until it is full
+func (c *Conn) readAll(buf []byte) error {
+	for read := 0; read < len(buf); {
+		n, err := c.conn.Read(buf[read:])
+		read += n
+		if err != nil && read < len(buf) {
+			return errors.Wrap(err, "read frame")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWriteFrameAndReadFrameKeepTypeAndPayload(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	payload := []byte("Santiago Lionel\nLorca|30904465")
+	go func() {
+		NewConn(left).WriteFrame(context.Background(), MsgEcho, payload)
+	}()
+
+	msgType, got, err := NewConn(right).ReadFrame(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msgType != MsgEcho {
+		t.Errorf("expected type %v, got %v", MsgEcho, msgType)
+	}
+	if !bytes.Equal(payload, got) {
+		t.Errorf("expected payload %q, got %q", payload, got)
+	}
+}
+
+func TestReadFrameWithTruncatedPayloadMustFail(t *testing.T) {
+	left, right := net.Pipe()
+	defer right.Close()
+
+	go func() {
+		// Header announces 10 bytes but only 3 are sent before closing
+		left.Write([]byte{byte(MsgEcho), 0, 0, 0, 10, 'a', 'b', 'c'})
+		left.Close()
+	}()
+
+	if _, _, err := NewConn(right).ReadFrame(context.Background()); err == nil {
+		t.Fatal("expected error reading truncated frame")
+	}
+}
+
+func TestReadFrameReturnsWhenContextIsCancelled(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if _, _, err := NewConn(right).ReadFrame(ctx); err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestReadFrameReturnsTimeoutErrorWhenServerHangs(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	conn := NewConn(right)
+	conn.SetTimeouts(10*time.Millisecond, 0)
+
+	_, _, err := conn.ReadFrame(context.Background())
+	if !IsTimeout(err) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+}
+
+func TestReadFrameLargerThanMaxFrameSizeMustFail(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+
+	go func() {
+		NewConn(left).WriteFrame(context.Background(), MsgEcho, make([]byte, 100))
+	}()
+
+	conn := NewConn(right)
+	conn.SetMaxFrameSize(10)
+	_, _, err := conn.ReadFrame(context.Background())
+	if !IsFrameTooLarge(err) {
+		t.Fatalf("expected frame too large error, got %v", err)
+	}
+	if err := conn.Close(); err != nil {
+		t.Errorf("expected closing an already closed connection to succeed, got %v", err)
+	}
+}
+
+func TestReadFrameWithCorruptedPayloadMustFail(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	go func() {
+		var buf bytes.Buffer
+		buf.Write([]byte{byte(MsgEcho), 0, 0, 0, 3, 'a', 'b', 'c'})
+		binary.Write(&buf, binary.BigEndian, checksum(buf.Bytes()))
+		frame := buf.Bytes()
+		// Flip a bit of the payload after computing the checksum
+		frame[HeaderSize] ^= 1
+		left.Write(frame)
+	}()
+
+	if _, _, err := NewConn(right).ReadFrame(context.Background()); !IsChecksumMismatch(err) {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+}
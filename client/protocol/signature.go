@@ -0,0 +1,23 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// SignatureSize Amount of bytes of the HMAC-SHA256 signature that follows
+// the payload of every frame once a signing key is set
+const SignatureSize = sha256.Size
+
+// sign Returns the HMAC-SHA256 of the header and payload of a frame
+func sign(key []byte, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// verify Returns whether signature is the HMAC-SHA256 of the header and
+// payload of a frame, comparing them in constant time
+func verify(key []byte, data []byte, signature []byte) bool {
+	return hmac.Equal(sign(key, data), signature)
+}
@@ -0,0 +1,49 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+)
+
+func TestSignedFrameRoundTrip(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	payload := []byte("Santiago Lionel\nLorca|30904465")
+	go func() {
+		conn := NewConn(left)
+		conn.SetSigningKey([]byte("secret"))
+		conn.WriteFrame(context.Background(), MsgBet, payload)
+	}()
+
+	conn := NewConn(right)
+	conn.SetSigningKey([]byte("secret"))
+	msgType, got, err := conn.ReadFrame(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if msgType != MsgBet || !bytes.Equal(payload, got) {
+		t.Errorf("expected original bet frame, got type %v with payload %q", msgType, got)
+	}
+}
+
+func TestReadFrameSignedWithAnotherKeyMustFail(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+
+	go func() {
+		conn := NewConn(left)
+		conn.SetSigningKey([]byte("forged"))
+		conn.WriteFrame(context.Background(), MsgAck, []byte("ok"))
+	}()
+
+	conn := NewConn(right)
+	conn.SetSigningKey([]byte("secret"))
+	if _, _, err := conn.ReadFrame(context.Background()); !IsSignatureInvalid(err) {
+		t.Fatalf("expected invalid signature error, got %v", err)
+	}
+}
@@ -7,6 +7,17 @@ Where n is the number of clients to generate
 """
 
 
+# Bets sent by the clients, each one taken as the agency environment
+# variables: first name, last name, document, birthdate and number
+BETS = [
+    ("Santiago Lionel", "Lorca", "30904465", "1999-03-17", "7574"),
+    ("Valentina", "Vera", "30170921", "1982-05-22", "6053"),
+    ("Martina", "Borges", "21073376", "1975-11-08", "1234"),
+    ("Julian", "Paz", "25111222", "1990-01-30", "4321"),
+    ("Lucia", "Diaz", "28333444", "1991-02-03", "200"),
+]
+
+
 def main(n):
     with open("docker-compose-dev.yaml", "w") as file:
         file.write('version: "3.9"\n')
@@ -48,12 +59,31 @@ def client(file, id):
     file.write("    environment:\n")
     file.write(f"      - CLI_ID={id}\n")
     file.write("      - CLI_LOG_LEVEL=DEBUG\n")
+    file.write("      - CLI_CHECKPOINT_PATH=/data/checkpoint.json\n")
+    file.write("      - CLI_REJECTS_PATH=/data/rejects.csv\n")
+    bet(file, id)
     file.write("    networks:\n")
     file.write("      - testing_net\n")
     file.write("    depends_on:\n")
     file.write("      - server\n")
     file.write("    volumes:\n")
     file.write("      - ./client/config.yaml:/config.yaml\n")
+    file.write("      - ./.data/dataset.zip:/dataset.zip:ro\n")
+    # The checkpoint and the rejects report live in a host directory per
+    # client, so they survive the container being recreated
+    file.write(f"      - ./.data/client{id}:/data\n")
+
+
+def bet(file, id):
+    """
+    Writes the environment variables of the bet sent by the given client id
+    """
+    first_name, last_name, document, birthdate, number = BETS[(id - 1) % len(BETS)]
+    file.write(f"      - NOMBRE={first_name}\n")
+    file.write(f"      - APELLIDO={last_name}\n")
+    file.write(f"      - DOCUMENTO={document}\n")
+    file.write(f"      - NACIMIENTO={birthdate}\n")
+    file.write(f"      - NUMERO={number}\n")
 
 
 def networks(file):